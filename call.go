@@ -0,0 +1,222 @@
+package wmic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CallMethod calls a method on the instances of the class matching the where
+// clause, e.g. StopService on Win32_Service, or on the class itself for static
+// methods such as Win32_Process Create if where is empty. wmic passes method
+// parameters by position in alphabetical order of their names, so params are
+// passed sorted by name. The ReturnValue and the other out parameters are
+// returned, from the first instance if several match
+func CallMethod(class, where, method string, params map[string]string) (int, map[string]string, error) {
+	ctx, cancel, err := withTimeout(context.Background(), DefaultTimeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cancel()
+	return callMethod(ctx, class, method, params, &QueryOptions{Where: where})
+}
+
+// callMethod runs wmic CALL for the method and parses the out parameters
+func callMethod(ctx context.Context, class, method string, params map[string]string, o *QueryOptions) (int, map[string]string, error) {
+	ctx, cancel := withShutdown(ctx, o)
+	defer cancel()
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+
+	err := checkNamespace(o.Namespace)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = checkClass(class)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !isIdentifier(method) {
+		return 0, nil, fmt.Errorf("Method %q is not a valid method name", method)
+	}
+
+	args := append(pathArgs(class, o), "CALL", method)
+	args = append(args, callParams(params)...)
+	if o.Debug != nil {
+		o.Debug(redactArgs(args))
+	}
+
+	stdout, stderr, err := runWmic(ctx, args, o)
+	if errors.Is(err, exec.ErrNotFound) {
+		return 0, nil, notFound(err)
+	}
+	stdout, stderr = transcode(stdout, o.Encoding), transcode(stderr, o.Encoding)
+	if noInstances(stdout) || noInstances(stderr) {
+		return 0, nil, ErrNoInstances
+	}
+	err = cmdError(err, stdout, stderr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out, err := parseParameters(newScanner(bytes.NewReader(stdout)))
+	if err != nil {
+		return 0, nil, err
+	}
+	rv, ok := out["ReturnValue"]
+	if !ok {
+		return 0, out, nil
+	}
+	delete(out, "ReturnValue")
+	code, err := strconv.Atoi(rv)
+	if err != nil {
+		return 0, out, fmt.Errorf("Invalid ReturnValue %s", rv)
+	}
+	return code, out, nil
+}
+
+// callParams returns the method parameters sorted by name, each quoted as a
+// single argument
+func callParams(params map[string]string) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, len(names))
+	for i, name := range names {
+		args[i] = quoteArg(params[name])
+	}
+	return args
+}
+
+// quoteArg quotes a method parameter or property value for wmic
+func quoteArg(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// parseParameters parses the first instance of __PARAMETERS in wmic CALL
+// output, e.g.
+//
+//	Executing (Win32_Process)->Create()
+//	Method execution successful.
+//	Out Parameters:
+//	instance of __PARAMETERS
+//	{
+//	        ProcessId = 4312;
+//	        ReturnValue = 0;
+//	};
+func parseParameters(scanner *bufio.Scanner) (map[string]string, error) {
+	out := map[string]string{}
+	inInstance := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "{":
+			inInstance = true
+		case line == "};":
+			if inInstance {
+				return out, nil
+			}
+		case inInstance:
+			parts := strings.SplitN(strings.TrimSuffix(line, ";"), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			out[strings.TrimSpace(parts[0])] = mofValue(strings.TrimSpace(parts[1]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inInstance {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return out, nil
+}
+
+// mofValue removes the quotes and escapes from a MOF string value, other
+// values are returned as is
+func mofValue(s string) string {
+	if len(s) < 2 || !strings.HasPrefix(s, `"`) || !strings.HasSuffix(s, `"`) {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// SetProperty sets writable properties on the instances of the class matching
+// the where clause. ErrNoInstances is returned if no instance matches, and a
+// WmicError matching ErrReadOnly if a property can't be written
+func SetProperty(class, where string, values map[string]string) error {
+	ctx, cancel, err := withTimeout(context.Background(), DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return setProperty(ctx, class, values, &QueryOptions{Where: where})
+}
+
+// setProperty runs wmic SET with the values sorted by property name
+func setProperty(ctx context.Context, class string, values map[string]string, o *QueryOptions) error {
+	ctx, cancel := withShutdown(ctx, o)
+	defer cancel()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err := checkNamespace(o.Namespace)
+	if err != nil {
+		return err
+	}
+	err = checkClass(class)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("You must provide at least one property to set")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	err = checkColumns(names)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = name + "=" + quoteArg(values[name])
+	}
+	args := append(pathArgs(class, o), "SET", strings.Join(assignments, ","))
+	if o.Debug != nil {
+		o.Debug(redactArgs(args))
+	}
+
+	stdout, stderr, err := runWmic(ctx, args, o)
+	if errors.Is(err, exec.ErrNotFound) {
+		return notFound(err)
+	}
+	stdout, stderr = transcode(stdout, o.Encoding), transcode(stderr, o.Encoding)
+	if noInstances(stdout) || noInstances(stderr) {
+		return ErrNoInstances
+	}
+	err = cmdError(err, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(bytes.ToLower(stdout), []byte("update successful")) {
+		return fmt.Errorf("Property update failed: %s", bytes.TrimSpace(stdout))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const createOutput = "Executing (Win32_Process)->Create()\r\r\nMethod execution successful.\r\r\nOut Parameters:\r\r\ninstance of __PARAMETERS\r\r\n{\r\r\n\tProcessId = 4312;\r\r\n\tReturnValue = 0;\r\r\n};\r\r\n\r\r\n"
+
+func TestCallMethod(t *testing.T) {
+
+	f := &fakeRunner{stdout: createOutput}
+	useRunner(t, f)
+	code, out, err := CallMethod("Win32_Process", "", "Create", map[string]string{
+		"CurrentDirectory": `C:\Windows`,
+		"CommandLine":      "notepad.exe",
+	})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	if code != 0 || out["ProcessId"] != "4312" || len(out) != 1 {
+		t.Fatalf("unexpected result %d %v", code, out)
+	}
+	got := strings.Join(f.args[0], " ")
+	if got != `PATH Win32_Process CALL Create "notepad.exe" "C:\Windows"` {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+
+	f = &fakeRunner{stdout: "Executing (\\\\PC\\ROOT\\CIMV2:Win32_Service.Name=\"Spooler\")->StopService()\r\r\nMethod execution successful.\r\r\nOut Parameters:\r\r\ninstance of __PARAMETERS\r\r\n{\r\r\n\tReturnValue = 5;\r\r\n};\r\r\n"}
+	code, _, err = callMethod(context.Background(), "Win32_Service", "StopService", nil, &QueryOptions{Runner: f, Where: "Name='Spooler'"})
+	if err != nil || code != 5 {
+		t.Fatalf("expected return code 5, got %d %v", code, err)
+	}
+	if got := strings.Join(f.args[0], " "); got != "PATH Win32_Service WHERE ( Name='Spooler' ) CALL StopService" {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "No Instance(s) Available.\r\n"})
+	_, _, err = CallMethod("Win32_Service", "Name='Missing'", "StopService", nil)
+	if err != ErrNoInstances {
+		t.Fatalf("expected ErrNoInstances, got %v", err)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "ERROR:\r\nDescription = Invalid method Parameter(s)\r\n", err: errors.New("exit status 2147749896")})
+	_, _, err = CallMethod("Win32_Process", "", "Create", nil)
+	var e *WmicError
+	if !errors.As(err, &e) || e.Description != "Invalid method Parameter(s)" {
+		t.Fatalf("expected a WmicError, got %v", err)
+	}
+
+}
+
+func TestParseParameters(t *testing.T) {
+
+	data := "instance of __PARAMETERS\r\n{\r\n\tName = \"C:\\\\Temp \\\"a\\\"\";\r\n\tSizes = {1, 2};\r\n};\r\n"
+	out, err := parseParameters(newScanner(strings.NewReader(data)))
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	if out["Name"] != `C:\Temp "a"` || out["Sizes"] != "{1, 2}" {
+		t.Fatalf("unexpected parameters %v", out)
+	}
+
+	_, err = parseParameters(newScanner(strings.NewReader("instance of __PARAMETERS\r\n{\r\n\tReturnValue = 0;\r\n")))
+	if err == nil {
+		t.Fatalf("expected an error for truncated output")
+	}
+
+}
+
+func TestSetProperty(t *testing.T) {
+
+	f := &fakeRunner{stdout: "Updating property(s) of '\\\\PC\\ROOT\\CIMV2:Win32_Environment.Handle=\"PC\",Name=\"TEMP\",UserName=\"PC\\\\user\"'\r\r\nProperty(s) update successful.\r\r\n"}
+	useRunner(t, f)
+	err := SetProperty("Win32_Environment", "Name='TEMP'", map[string]string{"VariableValue": `C:\Temp`, "Description": `say "hi"`})
+	if err != nil {
+		t.Fatalf("set failed: %s", err)
+	}
+	got := strings.Join(f.args[0], " ")
+	if got != `PATH Win32_Environment WHERE ( Name='TEMP' ) SET Description="say \"hi\"",VariableValue="C:\Temp"` {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "No Instance(s) Available.\r\n"})
+	err = SetProperty("Win32_Environment", "Name='Missing'", map[string]string{"VariableValue": "1"})
+	if err != ErrNoInstances {
+		t.Fatalf("expected ErrNoInstances, got %v", err)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "ERROR:\r\nCode = 0x80041023\r\nDescription = Property is read-only\r\nFacility = WMI\r\n", err: errors.New("exit status 2147749923")})
+	err = SetProperty("Win32_Service", "Name='Spooler'", map[string]string{"State": "Stopped"})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	useRunner(t, &fakeRunner{stdout: "Updating property(s) of 'Win32_Service.Name=\"Spooler\"'\r\r\n"})
+	err = SetProperty("Win32_Service", "Name='Spooler'", map[string]string{"DisplayName": "Spooler"})
+	if err == nil {
+		t.Fatalf("expected an error without the update successful message")
+	}
+
+	err = SetProperty("Win32_Service", "Name='Spooler'", nil)
+	if err == nil {
+		t.Fatalf("expected an error without any values")
+	}
+
+}
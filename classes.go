@@ -0,0 +1,39 @@
+package wmic
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// classRecord is an instance of meta_class, which has an instance for each
+// class in the namespace
+type classRecord struct {
+	Class string `wmi:"__CLASS"`
+}
+
+// ListClasses returns the sorted names of the classes in the namespace, or
+// the WithNamespace namespace, root\cimv2 by default, if empty, so with
+// ListProperties the schema can be browsed. Use WithClassPrefix to only
+// return some of them, e.g. the Win32_ classes
+func ListClasses(ctx context.Context, namespace string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	if namespace != "" {
+		opts = append(opts[:len(opts):len(opts)], WithNamespace(namespace))
+	}
+	records := []classRecord{}
+	_, err := QueryWQLContext(ctx, "SELECT __CLASS FROM meta_class", &records, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.ToLower(o.ClassPrefix)
+	names := []string{}
+	for _, r := range records {
+		if strings.HasPrefix(strings.ToLower(r.Class), prefix) {
+			names = append(names, r.Class)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,35 @@
+package wmic
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListClasses(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\n__CLASS=Win32_Service\r\r\n\r\r\n__CLASS=CIM_Service\r\r\n\r\r\n__CLASS=win32_BIOS\r\r\n\r\r\n__CLASS=__Namespace\r\r\n\r\r\n"}
+	names, err := ListClasses(context.Background(), `root\cimv2`, WithRunner(f), WithClassPrefix("WIN32_"))
+	if err != nil {
+		t.Fatalf("list failed: %s", err)
+	}
+	if got := strings.Join(f.args[0], " "); got != `/NAMESPACE:\\root\cimv2 PATH meta_class GET __CLASS /VALUE` {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+	if want := []string{"Win32_Service", "win32_BIOS"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %q, want %q", names, want)
+	}
+
+	names, err = ListClasses(context.Background(), "", WithRunner(f))
+	if err != nil || len(names) != 4 || names[0] != "CIM_Service" {
+		t.Fatalf("expected every class sorted, got %q %v", names, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = ListClasses(ctx, "", WithRunner(f)); err != context.Canceled {
+		t.Fatalf("expected the cancelled context's error, got %v", err)
+	}
+
+}
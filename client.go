@@ -0,0 +1,184 @@
+package wmic
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Client runs queries with a set of options, such as the timeout, node and
+// credentials, so they aren't repeated on every call. Options passed to a
+// method are applied after the client's. A Client is safe for concurrent
+// use, and clients with different options can be used at the same time
+type Client struct {
+	options []Option
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewClient returns a client that runs queries with the options
+func NewClient(opts ...Option) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{options: append([]Option{}, opts...), ctx: ctx, cancel: cancel}
+}
+
+// with returns the client's options followed by opts
+func (c *Client) with(opts ...Option) []Option {
+	all := append([]Option{}, c.options...)
+	if c.ctx != nil {
+		all = append(all, func(o *QueryOptions) {
+			o.client = c.ctx
+		})
+	}
+	return append(all, opts...)
+}
+
+// Close cancels the client's running queries, killing their processes, and
+// makes any later query with the client return context.Canceled. Other
+// clients and the package functions aren't affected, unlike Shutdown. It is
+// safe to call more than once
+func (c *Client) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return nil
+}
+
+// QueryWith is QueryWith with the client's options
+func (c *Client) QueryWith(class string, out interface{}, opts ...Option) ([]RecordError, error) {
+	return QueryWith(class, out, c.with(opts...)...)
+}
+
+// QueryWithContext is QueryWithContext with the client's options
+func (c *Client) QueryWithContext(ctx context.Context, class string, out interface{}, opts ...Option) ([]RecordError, error) {
+	return QueryWithContext(ctx, class, out, c.with(opts...)...)
+}
+
+// Query is Query with the client's options
+func (c *Client) Query(class string, columns []string, where string, out interface{}) ([]RecordError, error) {
+	return c.QueryWith(class, out, WithColumns(columns...), WithWhere(where))
+}
+
+// QueryContext is QueryContext with the client's options
+func (c *Client) QueryContext(ctx context.Context, class string, columns []string, where string, out interface{}) ([]RecordError, error) {
+	return c.QueryWithContext(ctx, class, out, WithColumns(columns...), WithWhere(where))
+}
+
+// QueryAll is QueryAll with the client's options
+func (c *Client) QueryAll(class string, out interface{}) ([]RecordError, error) {
+	return c.QueryWith(class, out)
+}
+
+// QueryColumns is QueryColumns with the client's options
+func (c *Client) QueryColumns(class string, columns []string, out interface{}) ([]RecordError, error) {
+	return c.QueryWith(class, out, WithColumns(columns...))
+}
+
+// QueryWhere is QueryWhere with the client's options
+func (c *Client) QueryWhere(class, where string, out interface{}) ([]RecordError, error) {
+	return c.QueryWith(class, out, WithWhere(where))
+}
+
+// QueryOne is QueryOne with the client's options
+func (c *Client) QueryOne(class string, where string, out interface{}) error {
+	return queryOne(class, where, out, c.with())
+}
+
+// QueryCount is QueryCount with the client's options
+func (c *Client) QueryCount(class, where string) (int, error) {
+	return queryCount(class, where, c.with())
+}
+
+// QueryMap is QueryMap with the client's options
+func (c *Client) QueryMap(class string, columns []string, where string) ([]map[string]string, error) {
+	return queryMap(class, columns, where, c.with())
+}
+
+// QueryJSON is QueryJSON with the client's options
+func (c *Client) QueryJSON(class string, columns []string, where string, opts ...Option) ([]byte, error) {
+	return QueryJSON(class, columns, where, c.with(opts...)...)
+}
+
+// QueryWQL is QueryWQL with the client's options
+func (c *Client) QueryWQL(wql string, out interface{}, opts ...Option) ([]RecordError, error) {
+	return QueryWQL(wql, out, c.with(opts...)...)
+}
+
+// Exists is Exists with the client's options
+func (c *Client) Exists(class, where string, opts ...Option) (bool, error) {
+	return Exists(class, where, c.with(opts...)...)
+}
+
+// ExistsContext is ExistsContext with the client's options
+func (c *Client) ExistsContext(ctx context.Context, class, where string, opts ...Option) (bool, error) {
+	return ExistsContext(ctx, class, where, c.with(opts...)...)
+}
+
+// QueryIter is QueryIter with the client's options
+func (c *Client) QueryIter(ctx context.Context, class string, columns []string, where string, out interface{}, opts ...Option) (*Iter, error) {
+	return QueryIter(ctx, class, columns, where, out, c.with(opts...)...)
+}
+
+// QueryFunc is QueryFunc with the client's options
+func (c *Client) QueryFunc(class string, columns []string, where string, out interface{}, fn func() error, opts ...Option) error {
+	return QueryFunc(class, columns, where, out, fn, c.with(opts...)...)
+}
+
+// QueryRows is QueryRows with the client's options
+func (c *Client) QueryRows(class string, columns []string, where string, opts ...Option) (*Rows, error) {
+	return QueryRows(class, columns, where, c.with(opts...)...)
+}
+
+// QueryCSV is QueryCSV with the client's options
+func (c *Client) QueryCSV(w io.Writer, class string, columns []string, where string, opts ...Option) error {
+	return QueryCSV(w, class, columns, where, c.with(opts...)...)
+}
+
+// ListProperties is ListProperties with the client's options
+func (c *Client) ListProperties(class string, opts ...Option) ([]string, error) {
+	return ListProperties(class, c.with(opts...)...)
+}
+
+// ValidateStruct is ValidateStruct with the client's options
+func (c *Client) ValidateStruct(class string, out interface{}, opts ...Option) ([]string, error) {
+	return ValidateStruct(class, out, c.with(opts...)...)
+}
+
+// ListClasses is ListClasses with the client's options, an empty namespace
+// is the client's namespace
+func (c *Client) ListClasses(ctx context.Context, namespace string, opts ...Option) ([]string, error) {
+	return ListClasses(ctx, namespace, c.with(opts...)...)
+}
+
+// WatchClient is Watch with the client's options. It is a function rather
+// than a method as methods can't have type parameters
+func WatchClient[T any](ctx context.Context, c *Client, class string, columns []string, where string, interval time.Duration, opts ...Option) (<-chan []T, error) {
+	return Watch[T](ctx, class, columns, where, interval, c.with(opts...)...)
+}
+
+// CallMethod is CallMethod with the client's options
+func (c *Client) CallMethod(class, where, method string, params map[string]string) (int, map[string]string, error) {
+	o := c.queryOptions(where)
+	ctx, cancel, err := withTimeout(context.Background(), o.timeout())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cancel()
+	return callMethod(ctx, class, method, params, o)
+}
+
+// SetProperty is SetProperty with the client's options
+func (c *Client) SetProperty(class, where string, values map[string]string) error {
+	o := c.queryOptions(where)
+	ctx, cancel, err := withTimeout(context.Background(), o.timeout())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return setProperty(ctx, class, values, o)
+}
+
+// queryOptions returns the client's options with the where clause
+func (c *Client) queryOptions(where string) *QueryOptions {
+	return newOptions(c.with(WithWhere(where)))
+}
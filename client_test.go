@@ -0,0 +1,143 @@
+package wmic
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	c := NewClient(WithRunner(f), WithNode("SERVER1"), WithNamespace(`root\cimv2`), WithExecutable(`C:\Windows\System32\wbem\WMIC.exe`))
+	out := []win32Service{}
+	_, err := c.QueryWhere("Win32_Service", "State='Running'", &out)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	args := strings.Join(f.args[0], " ")
+	if !strings.HasPrefix(args, `/NODE:"SERVER1" /NAMESPACE:\\root\cimv2 PATH Win32_Service`) || !strings.Contains(args, "WHERE ( State='Running' )") {
+		t.Fatalf("expected the client's options to be used, got %s", args)
+	}
+
+	_, err = c.QueryAll("Win32_Service", &out)
+	if err != nil || strings.Contains(strings.Join(f.args[1], " "), "WHERE") {
+		t.Fatalf("expected the where clause not to be kept between calls, got %v %v", f.args[1], err)
+	}
+
+	_, err = c.QueryWith("Win32_Service", &out, WithNode("SERVER2"))
+	if err != nil || !strings.HasPrefix(strings.Join(f.args[2], " "), `/NODE:"SERVER2"`) {
+		t.Fatalf("expected the method options to override the client's, got %v %v", f.args[2], err)
+	}
+
+	n, err := c.QueryCount("Win32_Service", "")
+	if err != nil || n != 2 || !strings.HasPrefix(strings.Join(f.args[3], " "), `/NODE:"SERVER1"`) {
+		t.Fatalf("expected the count to use the client's options, got %d %v %v", n, f.args[3], err)
+	}
+
+}
+
+func TestClientIter(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	c := NewClient(WithRunner(f), WithNode("SERVER1"), WithNamespace(`root\cimv2`))
+
+	ok, err := c.Exists("Win32_Service", "Name='Spooler'")
+	if err != nil || !ok {
+		t.Fatalf("expected an instance to exist, got %v %v", ok, err)
+	}
+	var s win32Service
+	it, err := c.QueryIter(context.Background(), "Win32_Service", nil, "", &s)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	for it.Next() {
+	}
+	it.Close()
+	if err := c.QueryFunc("Win32_Service", nil, "", &s, func() error { return nil }); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	rows, err := c.QueryRows("Win32_Service", []string{"Name"}, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	rows.Close()
+	var buf bytes.Buffer
+	if err := c.QueryCSV(&buf, "Win32_Service", []string{"Name"}, ""); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if names, err := c.ListProperties("Win32_Service"); err != nil || len(names) != 3 {
+		t.Fatalf("unexpected properties %v %v", names, err)
+	}
+	classes := &fakeRunner{stdout: "\r\r\n__CLASS=Win32_Service\r\r\n\r\r\n"}
+	if names, err := c.ListClasses(context.Background(), "", WithRunner(classes)); err != nil || len(names) != 1 {
+		t.Fatalf("list failed: %v %v", names, err)
+	}
+	if !strings.HasPrefix(strings.Join(classes.args[0], " "), `/NODE:"SERVER1" /NAMESPACE:\\root\cimv2 PATH`) {
+		t.Fatalf("expected the client's options to be used, got %q", classes.args[0])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots, err := WatchClient[win32Service](ctx, c, "Win32_Service", nil, "", time.Millisecond)
+	if err != nil {
+		t.Fatalf("watch failed: %s", err)
+	}
+	<-snapshots
+	cancel()
+	for range snapshots {
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Watch can take more than one snapshot before it is cancelled
+	if len(f.args) < 7 {
+		t.Fatalf("expected each method to use the client's runner, ran %q", f.args)
+	}
+	for _, args := range f.args {
+		if !strings.HasPrefix(strings.Join(args, " "), `/NODE:"SERVER1" /NAMESPACE:\\root\cimv2 PATH`) {
+			t.Fatalf("expected the client's options to be used, got %q", args)
+		}
+	}
+
+}
+
+func TestClientConcurrent(t *testing.T) {
+
+	fast := NewClient(WithRunner(&fakeRunner{stdout: serviceOutput}))
+	slow := NewClient(WithRunner(hangRunner{}), WithTimeout(10*time.Millisecond))
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		out := []win32Service{}
+		_, errs[0] = fast.QueryAll("Win32_Service", &out)
+	}()
+	go func() {
+		defer wg.Done()
+		out := []win32Service{}
+		_, errs[1] = slow.QueryAll("Win32_Service", &out)
+	}()
+	wg.Wait()
+	if errs[0] != nil || errs[1] == nil {
+		t.Fatalf("expected each client to use its own options, got %v", errs)
+	}
+
+}
+
+func TestExecutable(t *testing.T) {
+
+	b := &backendRunner{}
+	c := NewClient(WithRunner(b), WithBackend(BackendWMIC), WithExecutable(`C:\wmic\wmic.exe`))
+	out := []win32Service{}
+	c.QueryAll("Win32_Service", &out)
+	if len(b.names) != 1 || b.names[0] != `C:\wmic\wmic.exe` {
+		t.Fatalf("expected the executable to be run, ran %v", b.names)
+	}
+
+}
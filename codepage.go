@@ -0,0 +1,109 @@
+package wmic
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// codePages maps Windows code page identifiers to their encodings
+var codePages = map[uint32]encoding.Encoding{
+	437:   charmap.CodePage437,
+	850:   charmap.CodePage850,
+	852:   charmap.CodePage852,
+	855:   charmap.CodePage855,
+	858:   charmap.CodePage858,
+	860:   charmap.CodePage860,
+	862:   charmap.CodePage862,
+	863:   charmap.CodePage863,
+	865:   charmap.CodePage865,
+	866:   charmap.CodePage866,
+	874:   charmap.Windows874,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1250:  charmap.Windows1250,
+	1251:  charmap.Windows1251,
+	1252:  charmap.Windows1252,
+	1253:  charmap.Windows1253,
+	1254:  charmap.Windows1254,
+	1255:  charmap.Windows1255,
+	1256:  charmap.Windows1256,
+	1257:  charmap.Windows1257,
+	1258:  charmap.Windows1258,
+	20866: charmap.KOI8R,
+	28591: charmap.ISO8859_1,
+	65001: unicode.UTF8,
+}
+
+// outputEncoding returns the encoding set in the options, or the console code
+// page if none is set. Windows-1252 is used if the code page is unknown
+func outputEncoding(e encoding.Encoding) encoding.Encoding {
+	if e != nil {
+		return e
+	}
+	if e, ok := codePages[consoleCodePage()]; ok {
+		return e
+	}
+	return charmap.Windows1252
+}
+
+// transcode converts wmic output in the encoding e to UTF-8. If e is nil the
+// console code page is used, unless the output is already valid UTF-8. Output
+// with a byte order mark is returned as is for utf8Reader
+func transcode(b []byte, e encoding.Encoding) []byte {
+	if hasBOM(b) || (e == nil && utf8.Valid(b)) {
+		return b
+	}
+	out, err := outputEncoding(e).NewDecoder().Bytes(b)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+// transcodeReader is transcode for streamed output. If e is nil the encoding
+// is detected from the first read
+func transcodeReader(r io.Reader, e encoding.Encoding) io.Reader {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if e == nil {
+		// Only check what has already been read so streaming isn't delayed
+		br.Peek(1)
+		b, _ := br.Peek(br.Buffered())
+		if hasBOM(b) || validPrefix(b) {
+			return br
+		}
+	}
+	return transform.NewReader(br, outputEncoding(e).NewDecoder())
+}
+
+// validPrefix returns true if b is valid UTF-8, allowing for a rune cut off at
+// the end
+func validPrefix(b []byte) bool {
+	if utf8.Valid(b) {
+		return true
+	}
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return !utf8.FullRune(b[i:]) && utf8.Valid(b[:i])
+		}
+	}
+	return false
+}
+
+// hasBOM returns true if the output starts with a UTF-8 or UTF-16 byte order
+// mark
+func hasBOM(b []byte) bool {
+	return bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}) || bytes.HasPrefix(b, []byte{0xFF, 0xFE})
+}
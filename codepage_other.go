@@ -0,0 +1,8 @@
+//go:build !windows
+
+package wmic
+
+// consoleCodePage returns 0 as there is no console code page outside Windows
+func consoleCodePage() uint32 {
+	return 0
+}
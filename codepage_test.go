@@ -0,0 +1,60 @@
+package wmic
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestDecodeCodePage(t *testing.T) {
+
+	// José Müller in Windows-1252
+	data := "\r\r\nName=Jos\xe9\r\r\nStartName=M\xfcller\r\r\n\r\r\n"
+	for _, opts := range [][]Option{nil, {WithEncoding(charmap.Windows1252)}} {
+		out := []win32Service{}
+		_, err := DecodeValue([]byte(data), &out, opts...)
+		if err != nil {
+			t.Fatalf("decode failed: %s", err)
+		}
+		if len(out) != 1 || out[0].Name != "José" || out[0].StartName != "Müller" {
+			t.Fatalf("expected the accents to be decoded, got %v", out)
+		}
+	}
+
+	f := &fakeRunner{stdout: data}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithEncoding(charmap.Windows1252))
+	if err != nil || len(out) != 1 || out[0].Name != "José" {
+		t.Fatalf("expected the query output to be decoded, got %v %v", out, err)
+	}
+
+}
+
+func TestTranscode(t *testing.T) {
+
+	utf8 := []byte("Name=José\r\n")
+	if !bytes.Equal(transcode(utf8, nil), utf8) {
+		t.Fatalf("expected UTF-8 output to be unchanged")
+	}
+	utf16 := []byte{0xFF, 0xFE, 'N', 0}
+	if !bytes.Equal(transcode(utf16, charmap.Windows1252), utf16) {
+		t.Fatalf("expected UTF-16 output to be unchanged")
+	}
+	sjis, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte("Name=日本\r\n"))
+	if string(transcode(sjis, japanese.ShiftJIS)) != "Name=日本\r\n" {
+		t.Fatalf("expected Shift JIS to be decoded, got %q", transcode(sjis, japanese.ShiftJIS))
+	}
+
+	b, _ := io.ReadAll(transcodeReader(bytes.NewReader([]byte("Name=Jos\xe9\r\n")), nil))
+	if string(b) != "Name=José\r\n" {
+		t.Fatalf("expected streamed output to be decoded, got %q", b)
+	}
+	b, _ = io.ReadAll(transcodeReader(bytes.NewReader(utf8), nil))
+	if !bytes.Equal(b, utf8) {
+		t.Fatalf("expected streamed UTF-8 output to be unchanged, got %q", b)
+	}
+
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package wmic
+
+import "syscall"
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleOutputCP = kernel32.NewProc("GetConsoleOutputCP")
+	procGetOEMCP           = kernel32.NewProc("GetOEMCP")
+)
+
+// consoleCodePage returns the console output code page, or the OEM code page
+// if there is no console, which is what wmic writes its output in
+func consoleCodePage() uint32 {
+	cp, _, _ := procGetConsoleOutputCP.Call()
+	if cp == 0 {
+		cp, _, _ = procGetOEMCP.Call()
+	}
+	return uint32(cp)
+}
@@ -0,0 +1,45 @@
+package wmic
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// QueryCSV writes the instances to w as CSV with a header row of property
+// names. The columns are in the order requested, or the order wmic outputs
+// them if columns is empty. Nothing is written for a query of every property
+// without instances as the names aren't known
+func QueryCSV(w io.Writer, class string, columns []string, where string, opts ...Option) error {
+	rows, err := QueryRows(class, columns, where, opts...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	header := false
+	if rows.Columns() != nil {
+		header = true
+		cw.Write(rows.Columns())
+	}
+	for rows.Next() {
+		if !header {
+			header = true
+			cw.Write(rows.Columns())
+		}
+		record := make([]string, len(rows.Columns()))
+		for i, c := range rows.Columns() {
+			record[i] = rows.values[c]
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	cw.Flush()
+	return cw.Error()
+}
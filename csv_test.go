@@ -0,0 +1,44 @@
+package wmic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQueryCSV(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\nCommandLine=\"C:\\Windows\\system32\\svchost.exe\" -k netsvcs, -p\r\r\nName=svchost.exe\r\r\nProcessId=1068\r\r\n\r\r\n" +
+		"\r\r\nCommandLine=\r\r\nName=System\r\r\nProcessId=4\r\r\n\r\r\n"}
+	useRunner(t, f)
+	var buf bytes.Buffer
+	err := QueryCSV(&buf, "Win32_Process", []string{"ProcessId", "Name", "CommandLine"}, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	want := "ProcessId,Name,CommandLine\r\n" +
+		"1068,svchost.exe,\"\"\"C:\\Windows\\system32\\svchost.exe\"\" -k netsvcs, -p\"\r\n" +
+		"4,System,\r\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected CSV %q", buf.String())
+	}
+
+	buf.Reset()
+	err = QueryCSV(&buf, "Win32_Process", nil, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	want = "CommandLine,Name,ProcessId\r\n" +
+		"\"\"\"C:\\Windows\\system32\\svchost.exe\"\" -k netsvcs, -p\",svchost.exe,1068\r\n" +
+		",System,4\r\n"
+	if buf.String() != want {
+		t.Fatalf("expected the columns in output order, got %q", buf.String())
+	}
+
+	buf.Reset()
+	useRunner(t, &fakeRunner{stdout: "No Instance(s) Available.\r\n"})
+	err = QueryCSV(&buf, "Win32_Process", []string{"ProcessId"}, "Name='none'")
+	if err != nil || buf.String() != "ProcessId\r\n" {
+		t.Fatalf("expected only the header, got %q %v", buf.String(), err)
+	}
+
+}
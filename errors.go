@@ -0,0 +1,164 @@
+package wmic
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sentinel errors matched by WmicError with errors.Is
+var (
+	ErrAccessDenied = errors.New("Access denied")
+	ErrInvalidClass = errors.New("Invalid class")
+	ErrInvalidQuery = errors.New("Invalid query")
+	// ErrInstanceNotFound is returned when there is no instance at an object
+	// path
+	ErrInstanceNotFound = errors.New("Instance not found")
+	ErrReadOnly         = errors.New("Property is read-only")
+	// ErrRPCUnavailable is usually a transient network failure
+	ErrRPCUnavailable = errors.New("RPC server unavailable")
+)
+
+// wmicErrors maps the sentinel errors to their wmic error code and the
+// descriptions wmic reports for them
+var wmicErrors = map[error]struct {
+	code         string
+	descriptions []string
+}{
+	ErrAccessDenied:     {"0x80070005", []string{"access denied", "access is denied"}},
+	ErrInvalidClass:     {"0x80041010", []string{"invalid class"}},
+	ErrInvalidQuery:     {"0x80041017", []string{"invalid query"}},
+	ErrInstanceNotFound: {"0x80041002", []string{"not found"}},
+	ErrReadOnly:         {"0x80041023", []string{"read-only", "read only"}},
+	ErrRPCUnavailable:   {"0x800706BA", []string{"rpc server is unavailable"}},
+}
+
+// WmicError is an error reported by wmic on stderr, such as
+//
+//	Node - SERVER1
+//	ERROR:
+//	Code = 0x80070005
+//	Description = Access is denied.
+//	Facility = Win32
+type WmicError struct {
+	// Node is the computer the error is for, if wmic reported it
+	Node string
+	// Code is the hex error code, if wmic reported it
+	Code string
+	// Description is the error description, if wmic reported it
+	Description string
+	// Raw is the stderr output
+	Raw string
+	// ExitCode is the exit code of wmic, or -1 if it isn't known
+	ExitCode int
+	// Output is the stdout output for a failure that wmic didn't report on
+	// stderr
+	Output string
+	// Err is the error from running wmic, usually an *exec.ExitError
+	Err error
+}
+
+func (e *WmicError) Error() string {
+	msg := e.Description
+	if msg == "" {
+		msg = strings.TrimSpace(e.Raw)
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("wmic exited with code %d (0x%08X)", e.ExitCode, uint32(e.ExitCode))
+		if output := strings.TrimSpace(e.Output); output != "" {
+			msg += ": " + truncate(output, maxErrorOutput)
+		}
+	}
+	if e.Node != "" {
+		msg = fmt.Sprintf("%s: %s", e.Node, msg)
+	}
+	return msg
+}
+
+// Unwrap returns the error from running wmic
+func (e *WmicError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel errors by code or description
+func (e *WmicError) Is(target error) bool {
+	match, ok := wmicErrors[target]
+	if !ok {
+		return false
+	}
+	if e.Code != "" {
+		return strings.EqualFold(e.Code, match.code)
+	}
+	text := strings.ToLower(e.Description)
+	if text == "" {
+		text = strings.ToLower(e.Raw)
+	}
+	for _, d := range match.descriptions {
+		if strings.Contains(text, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// newWmicError parses wmic stderr output into a WmicError
+func newWmicError(stderr []byte, err error) *WmicError {
+	e := &WmicError{Raw: string(stderr), ExitCode: -1, Err: err}
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) {
+		e.ExitCode = exit.ExitCode()
+	}
+	scanner := bufio.NewScanner(utf8Reader(strings.NewReader(e.Raw)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Node - ") {
+			e.Node = strings.TrimSpace(strings.TrimPrefix(line, "Node - "))
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "Code":
+			e.Code = value
+		case "Description":
+			e.Description = value
+		}
+	}
+	if e.Code == "" {
+		e.Code = exitCode(err)
+	}
+	return e
+}
+
+// maxErrorOutput is the most stdout output included in an error message
+const maxErrorOutput = 200
+
+// truncate shortens s to at most n bytes, without splitting a rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+// exitCode returns the HRESULT wmic exited with as a hex code, or an empty
+// string if the exit code is not an HRESULT failure
+func exitCode(err error) string {
+	var exit interface{ ExitCode() int }
+	if !errors.As(err, &exit) {
+		return ""
+	}
+	code := uint32(exit.ExitCode())
+	if code&0x80000000 == 0 {
+		return ""
+	}
+	return fmt.Sprintf("0x%08X", code)
+}
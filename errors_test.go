@@ -0,0 +1,123 @@
+package wmic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWmicError(t *testing.T) {
+
+	exitErr := errors.New("exit status 2147749890")
+	e := newWmicError([]byte("Node - SERVER1\r\nERROR:\r\nCode = 0x80070005\r\nDescription = Access is denied.\r\nFacility = Win32\r\n"), exitErr)
+	if e.Node != "SERVER1" || e.Code != "0x80070005" || e.Description != "Access is denied." {
+		t.Fatalf("unexpected error fields %+v", e)
+	}
+	if e.Error() != "SERVER1: Access is denied." {
+		t.Fatalf("unexpected message %s", e.Error())
+	}
+	var err error = e
+	if !errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrInvalidClass) || !errors.Is(err, exitErr) {
+		t.Fatalf("unexpected errors.Is results for %v", err)
+	}
+
+	err = newWmicError([]byte("ERROR:\r\nDescription = Invalid class \r\n"), nil)
+	if !errors.Is(err, ErrInvalidClass) || errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidClass for %v", err)
+	}
+
+	err = newWmicError([]byte("Node - SERVER2\r\nERROR:\r\nDescription = Access denied\r\n"), nil)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied for %v", err)
+	}
+
+	err = newWmicError([]byte("Invalid query\r\n"), nil)
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for %v", err)
+	}
+
+}
+
+func TestQueryWmicError(t *testing.T) {
+
+	f := &fakeRunner{stderr: "ERROR:\r\nDescription = Invalid class \r\n", err: errors.New("exit status 44135")}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Servic", &out, WithRunner(f))
+	var e *WmicError
+	if !errors.As(err, &e) || !errors.Is(err, ErrInvalidClass) {
+		t.Fatalf("expected a WmicError for an invalid class, got %v", err)
+	}
+
+}
+
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitError) ExitCode() int {
+	return int(e)
+}
+
+func TestAccessDenied(t *testing.T) {
+
+	tests := []struct {
+		stderr string
+		err    error
+		denied bool
+	}{
+		{stderr: "Node - SERVER1\r\nERROR:\r\nCode = 0x80070005\r\nDescription = Access is denied.\r\nFacility = Win32\r\n", denied: true},
+		{stderr: "", err: exitError(0x80070005), denied: true},
+		{stderr: "Node - SERVER1\r\nERROR:\r\nCode = 0x800706ba\r\nDescription = The RPC server is unavailable.\r\nFacility = Win32\r\n", err: exitError(0x800706BA)},
+		{stderr: "", err: exitError(0x800706BA)},
+		{stderr: "ERROR:\r\nDescription = Invalid class \r\n", err: exitError(44135)},
+		{stderr: "", err: exitError(1)},
+	}
+
+	for _, test := range tests {
+		err := cmdError(test.err, nil, []byte(test.stderr))
+		if errors.Is(err, ErrAccessDenied) != test.denied {
+			t.Fatalf("expected errors.Is(%v, ErrAccessDenied) to be %t", err, test.denied)
+		}
+	}
+
+}
+
+func TestExitCodeWithoutStderr(t *testing.T) {
+
+	f := &fakeRunner{stdout: "Invalid GET Expression.\r\n", err: exitError(1)}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f))
+	var e *WmicError
+	if !errors.As(err, &e) || e.ExitCode != 1 || e.Output != "Invalid GET Expression.\r\n" {
+		t.Fatalf("expected a WmicError with the exit code and output, got %#v", err)
+	}
+	if err.Error() != "wmic exited with code 1 (0x00000001): Invalid GET Expression." {
+		t.Fatalf("unexpected message %s", err)
+	}
+	if !errors.Is(err, exitError(1)) {
+		t.Fatalf("expected the exit error to be wrapped")
+	}
+
+	err = cmdError(exitError(2), []byte(strings.Repeat("é", 150)), nil)
+	if msg := err.Error(); !strings.HasSuffix(msg, "...") || !utf8.ValidString(msg) || len(msg) > 250 {
+		t.Fatalf("expected the output to be truncated, got %s", msg)
+	}
+
+	// An HRESULT exit code keeps the output too
+	err = cmdError(exitError(0x80041017), []byte("Invalid query\r\n"), nil)
+	if !errors.As(err, &e) || e.Output != "Invalid query\r\n" || e.Code != "0x80041017" || !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected a WmicError with the code and output, got %#v", err)
+	}
+	if err.Error() != "wmic exited with code 2147749911 (0x80041017): Invalid query" {
+		t.Fatalf("unexpected message %s", err)
+	}
+
+	if err = cmdError(errors.New("signal: killed"), []byte("partial"), nil); err.Error() != "signal: killed" {
+		t.Fatalf("expected errors without an exit code to be returned as is, got %v", err)
+	}
+
+}
@@ -0,0 +1,37 @@
+package wmic
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// GUID is a WMI GUID property such as Win32_ComputerSystemProduct.UUID, with
+// the bytes in the order they are written
+type GUID [16]byte
+
+// UnmarshalText parses a GUID written as XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX.
+// If the value has braces the GUID is read from between them, so
+// Win32_Volume.DeviceID values like \\?\Volume{...}\ can be parsed
+func (g *GUID) UnmarshalText(text []byte) error {
+	s := string(text)
+	if start, end := strings.Index(s, "{"), strings.LastIndex(s, "}"); start >= 0 && end > start {
+		s = s[start+1 : end]
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return fmt.Errorf("Invalid GUID %s", text)
+	}
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return fmt.Errorf("Invalid GUID %s", text)
+	}
+	copy(g[:], b)
+	return nil
+}
+
+// String returns the GUID in braces in upper case, as WMI writes them
+func (g GUID) String() string {
+	h := strings.ToUpper(hex.EncodeToString(g[:]))
+	return "{" + h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:] + "}"
+}
@@ -0,0 +1,38 @@
+package wmic
+
+import (
+	"strings"
+	"testing"
+)
+
+type volume struct {
+	DeviceID GUID
+	Name     string
+	UUID     *GUID
+}
+
+func TestDecodeGUID(t *testing.T) {
+
+	data := "\r\r\nDeviceID=\\\\?\\Volume{3e8b6a5c-0f2d-11e9-a2f1-806e6f6e6963}\\\r\r\nName=C:\\\r\r\nUUID=4C4C4544-0042-3510-8052-B8C04F4E4D32\r\r\n\r\r\n"
+	out := []volume{}
+	errs, err := decode(strings.NewReader(data), "Win32_Volume", &out, &QueryOptions{})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	if len(out) != 1 || out[0].DeviceID.String() != "{3E8B6A5C-0F2D-11E9-A2F1-806E6F6E6963}" || out[0].DeviceID[0] != 0x3e {
+		t.Fatalf("unexpected device id %v", out)
+	}
+	if out[0].UUID == nil || out[0].UUID.String() != "{4C4C4544-0042-3510-8052-B8C04F4E4D32}" {
+		t.Fatalf("unexpected uuid %v", out[0].UUID)
+	}
+
+	data = "\r\r\nDeviceID={3e8b6a5c-0f2d-11e9-a2f1}\r\r\nName=D:\\\r\r\n\r\r\n"
+	errs, err = decode(strings.NewReader(data), "Win32_Volume", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(errs) != 1 || errs[0].Field != "DeviceID" || len(out) != 1 || out[0].Name != `D:\` {
+		t.Fatalf("expected a record error for the malformed GUID, got %v %v", errs, out)
+	}
+
+}
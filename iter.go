@@ -0,0 +1,255 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+)
+
+// Iter decodes the records of a query one at a time as wmic writes them, so
+// large result sets don't need to be held in memory
+type Iter struct {
+	class   string
+	o       *QueryOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wait    func() ([]byte, error)
+	records recordSource
+	// args are the wmic arguments, nil for the PowerShell backend
+	args []string
+	// count is the number of records read so far
+	count        int
+	props        []property
+	recordErrors []RecordError
+	err          error
+	closed       bool
+}
+
+// QueryIter starts a query and returns an iterator over its records. out is a
+// pointer to the struct type that will be passed to Scan, its fields are used
+// for the GET list if columns is empty. If wmic isn't installed the query is
+// run with PowerShell, whose output is decoded as it is read. The options
+// apply as for QueryWith, except that the timeout, retries, order and
+// Decoder aren't used as the records aren't held, use ctx to stop the query.
+// The iterator must be closed
+func QueryIter(ctx context.Context, class string, columns []string, where string, out interface{}, opts ...Option) (*Iter, error) {
+	t := reflect.TypeOf(out)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("You must provide a pointer to a struct to the out argument")
+	}
+
+	o := newOptions(append([]Option{WithColumns(columns...), WithWhere(where)}, opts...))
+	// The records are read as name=value lines
+	o.Format = FormatValue
+	if err := checkNamespace(o.Namespace); err != nil {
+		return nil, err
+	}
+	if err := checkQuery(class, t.Elem(), o); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withShutdown(ctx, o)
+	if ctx.Err() != nil {
+		cancel()
+		return nil, ctx.Err()
+	}
+	if o.Backend == BackendPowerShell {
+		return startPowerShell(ctx, cancel, class, t.Elem(), o)
+	}
+	args := buildArgs(class, t.Elem(), o)
+	if o.Debug != nil {
+		o.Debug(redactArgs(args))
+	}
+	stdout, wait, err := start(ctx, o.runner(), o.executable(), args)
+	if errors.Is(err, exec.ErrNotFound) {
+		if o.Backend == BackendAuto {
+			// wmic has been removed from recent versions of Windows
+			it, psErr := startPowerShell(ctx, cancel, class, t.Elem(), o)
+			if !errors.Is(psErr, exec.ErrNotFound) {
+				return it, psErr
+			}
+		}
+		err = notFound(err)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	it := &Iter{class: class, o: o, ctx: ctx, cancel: cancel, wait: wait, args: args}
+	it.records = newRecordReader(transcodeReader(stdout, o.Encoding))
+	return it, nil
+}
+
+// startPowerShell starts the query with the PowerShell backend for when wmic
+// isn't installed. cancel is called if it fails to start
+func startPowerShell(ctx context.Context, cancel context.CancelFunc, class string, innerType reflect.Type, o *QueryOptions) (*Iter, error) {
+	args, err := powerShellArgs(class, innerType, o)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdout, wait, err := start(ctx, o.runner(), "powershell", args)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	it := &Iter{class: class, o: o, ctx: ctx, cancel: cancel, wait: wait}
+	it.records = newJSONReader(transcodeReader(stdout, o.Encoding))
+	return it, nil
+}
+
+// QueryFunc populates out, a pointer to a struct, from each record in turn and
+// calls fn after each one. An error from fn stops the query and kills wmic.
+// Fields that fail to parse are left at their zero value
+func QueryFunc(class string, columns []string, where string, out interface{}, fn func() error, opts ...Option) error {
+	ctx, cancel, err := withTimeout(context.Background(), newOptions(opts).timeout())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	it, err := QueryIter(ctx, class, columns, where, out, opts...)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	v := reflect.ValueOf(out).Elem()
+	for it.Next() {
+		// Clear the previous record so its values aren't carried over
+		v.Set(reflect.Zero(v.Type()))
+		err = it.Scan(out)
+		if err != nil {
+			return err
+		}
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Next reads the next record for Scan. It returns false when there are no more
+// records or on an error, which is returned by Err
+func (it *Iter) Next() bool {
+	it.props = nil
+	if it.closed {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	props, err := it.records.next()
+	if err != nil {
+		if err != io.EOF {
+			it.err = err
+			it.Close()
+			return false
+		}
+		if it.finish() {
+			// wmic was run again without the switches it doesn't support
+			return it.Next()
+		}
+		return false
+	}
+	it.count++
+	it.props = props
+	return true
+}
+
+// Scan decodes the current record into out, a pointer to a struct. Values that
+// fail to parse are available from RecordErrors
+func (it *Iter) Scan(out interface{}) error {
+	if it.props == nil {
+		return errors.New("Scan called without a successful call to Next")
+	}
+	errs, err := decodeRecord(it.class, it.count, it.props, out, it.o)
+	it.recordErrors = append(it.recordErrors, errs...)
+	return err
+}
+
+// RecordErrors returns the values that failed to parse in the records scanned
+func (it *Iter) RecordErrors() []RecordError {
+	return it.recordErrors
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// Close stops the query, killing wmic if it is still running. It is safe to
+// call Close more than once
+func (it *Iter) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	it.props = nil
+	it.cancel()
+	// The process was killed so its exit error is expected
+	it.wait()
+	return nil
+}
+
+// finish waits for wmic to exit after all of its output has been read. It
+// returns true if this version of wmic doesn't support the /INTERACTIVE or
+// /FAILFAST switches and it was started again without them
+func (it *Iter) finish() bool {
+	stderr, err := it.wait()
+	stderr = transcode(stderr, it.o.Encoding)
+	if err != nil && it.ctx.Err() == nil && it.count == 0 && it.args != nil &&
+		(it.o.NonInteractive || it.o.FailFast) && invalidSwitch(stderr) {
+		it.args = withoutSwitches(it.args)
+		stdout, wait, startErr := start(it.ctx, it.o.runner(), it.o.executable(), it.args)
+		if startErr == nil {
+			it.wait = wait
+			it.records = newRecordReader(transcodeReader(stdout, it.o.Encoding))
+			return true
+		}
+		err = startErr
+	}
+	it.closed = true
+	if it.ctx.Err() != nil {
+		err = it.ctx.Err()
+	}
+	it.cancel()
+	it.err = cmdError(err, nil, stderr)
+	return false
+}
+
+// ListProperties returns the property names of the class in output order,
+// read from the first instance. The wmic process is stopped after the first
+// instance so this is quick even for classes with many instances.
+// ErrNoInstances is returned if the class has no instances
+func ListProperties(class string, opts ...Option) ([]string, error) {
+	ctx, cancel, err := withTimeout(context.Background(), newOptions(opts).timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	it, err := QueryIter(ctx, class, []string{AllColumns}, "", &rawRecord{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	if !it.Next() {
+		if it.Err() != nil {
+			return nil, it.Err()
+		}
+		return nil, ErrNoInstances
+	}
+	names := make([]string, len(it.props))
+	for i, p := range it.props {
+		names[i] = p.name
+	}
+	return names, nil
+}
@@ -0,0 +1,48 @@
+package wmic
+
+import (
+	"encoding/json"
+)
+
+// QueryJSON returns the instances as a JSON array of objects keyed by
+// property name. Values that are valid JSON numbers are written as numbers
+// unless WithJSONStrings is set. All the properties are returned if columns
+// is empty
+func QueryJSON(class string, columns []string, where string, opts ...Option) ([]byte, error) {
+	records, err := queryMap(class, columns, where, opts)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	objects := make([]map[string]interface{}, len(records))
+	for i, r := range records {
+		objects[i] = jsonObject(r, o.JSONStrings)
+	}
+	return json.Marshal(objects)
+}
+
+// jsonObject converts the raw values of an instance to JSON values
+func jsonObject(record map[string]string, keepStrings bool) map[string]interface{} {
+	object := make(map[string]interface{}, len(record))
+	for name, value := range record {
+		if !keepStrings && isJSONNumber(value) {
+			object[name] = json.Number(value)
+		} else {
+			object[name] = value
+		}
+	}
+	return object
+}
+
+// isJSONNumber returns true if s is a valid JSON number, so values with
+// leading zeros such as "0012" stay strings
+func isJSONNumber(s string) bool {
+	if s == "" || (s[0] != '-' && !isDigit(s[0])) || !isDigit(s[len(s)-1]) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
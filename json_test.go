@@ -0,0 +1,36 @@
+package wmic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryJSON(t *testing.T) {
+
+	data := "\r\r\nCapacity=0012\r\r\nDeviceID=C:\r\r\nFreeSpace=-1.5e3\r\r\nSize=256060514304\r\r\n\r\r\n" +
+		"\r\r\nCapacity=\r\r\nDeviceID=\"D:\"\r\r\nFreeSpace=10\r\r\nSize=1\r\r\n\r\r\n"
+	f := &fakeRunner{stdout: data}
+	out, err := QueryJSON("Win32_LogicalDisk", []string{"DeviceID", "Size"}, "DriveType=3", WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	want := `[{"Capacity":"0012","DeviceID":"C:","FreeSpace":-1.5e3,"Size":256060514304},` +
+		`{"Capacity":"","DeviceID":"\"D:\"","FreeSpace":10,"Size":1}]`
+	if string(out) != want {
+		t.Fatalf("unexpected JSON %s", out)
+	}
+	if !strings.Contains(strings.Join(f.args[0], " "), "GET DeviceID,Size ") {
+		t.Fatalf("expected the columns to be queried, got %v", f.args[0])
+	}
+
+	out, err = QueryJSON("Win32_LogicalDisk", nil, "", WithRunner(f), WithJSONStrings())
+	if err != nil || !strings.Contains(string(out), `"Size":"256060514304"`) {
+		t.Fatalf("expected the values as strings, got %s %v", out, err)
+	}
+
+	out, err = QueryJSON("Win32_LogicalDisk", nil, "", WithRunner(&fakeRunner{stdout: "No Instance(s) Available.\r\n"}))
+	if err != nil || string(out) != "[]" {
+		t.Fatalf("expected an empty array, got %s %v", out, err)
+	}
+
+}
@@ -0,0 +1,160 @@
+package wmic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// nodeProperty is the tag of a field that is set to the node a record came
+// from rather than from a WMI property
+const nodeProperty = "__node"
+
+// defaultParallelism is the number of nodes queried at once if not set
+const defaultParallelism = 4
+
+// QueryNodeFile runs the query against every node listed in the file at path,
+// one hostname or IP address per line. Blank lines and lines starting with #
+// are skipped. Failed nodes are returned as NodeErrors like WithNode
+func QueryNodeFile(path string, class string, out interface{}, opts ...Option) ([]RecordError, error) {
+	nodes, err := ReadNodeFile(path)
+	if err != nil {
+		return []RecordError{}, err
+	}
+	return QueryWith(class, out, append(opts, WithNode(nodes...))...)
+}
+
+// ReadNodeFile reads the nodes listed in the file at path, one per line,
+// skipping blank lines and # comments
+func ReadNodeFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nodes := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nodes = append(nodes, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("No nodes in %s", path)
+	}
+	return nodes, nil
+}
+
+// NodeError is the error from one node when querying several
+type NodeError struct {
+	Node string
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Node, e.Err)
+}
+
+// Unwrap returns the error from the node
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// NodeErrors is returned when some of several nodes fail. The records from
+// the other nodes are still returned in the out slice
+type NodeErrors []*NodeError
+
+func (e NodeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error from each node for errors.Is and errors.As
+func (e NodeErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// nodeResult is the outcome of querying one node
+type nodeResult struct {
+	out          reflect.Value
+	recordErrors []RecordError
+	err          error
+}
+
+// queryNodes runs the query against the nodes concurrently and concatenates
+// the results into the out slice in node order. The nodes that fail are
+// returned as NodeErrors
+func queryNodes(ctx context.Context, class string, outerValue reflect.Value, innerType reflect.Type, o *QueryOptions) ([]RecordError, error) {
+	parallelism := o.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	results := make([]nodeResult, len(o.Nodes))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, node := range o.Nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			nodeOptions := *o
+			nodeOptions.Nodes = []string{node}
+			nodeOut := reflect.New(outerValue.Type())
+			errs, err := runRetry(ctx, class, nodeOut.Interface(), innerType, &nodeOptions)
+			setNode(nodeOut.Elem(), node)
+			results[i] = nodeResult{out: nodeOut.Elem(), recordErrors: errs, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+
+	recordErrors := []RecordError{}
+	var nodeErrors NodeErrors
+	result := reflect.MakeSlice(outerValue.Type(), 0, 0)
+	for i, r := range results {
+		recordErrors = append(recordErrors, r.recordErrors...)
+		if r.err != nil {
+			nodeErrors = append(nodeErrors, &NodeError{Node: o.Nodes[i], Err: r.err})
+			continue
+		}
+		result = reflect.AppendSlice(result, r.out)
+	}
+	outerValue.Set(result)
+	if len(nodeErrors) > 0 {
+		return recordErrors, nodeErrors
+	}
+	return recordErrors, nil
+}
+
+// setNode sets the field tagged wmi:"__node" of each record to the node
+func setNode(records reflect.Value, node string) {
+	for i := 0; i < records.Len(); i++ {
+		item := records.Index(i)
+		if item.Kind() == reflect.Ptr {
+			item = item.Elem()
+		}
+		f, _, _ := fieldByProperty(item, nodeProperty, false)
+		if f.IsValid() && f.Kind() == reflect.String {
+			f.SetString(node)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// nodeRunner fails for the nodes in fail and records the most nodes queried
+// at once
+type nodeRunner struct {
+	fail    map[string]bool
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (n *nodeRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	n.mu.Lock()
+	n.running++
+	if n.running > n.max {
+		n.max = n.running
+	}
+	n.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	n.mu.Lock()
+	n.running--
+	n.mu.Unlock()
+
+	node := strings.Trim(strings.TrimPrefix(args[0], "/NODE:"), `"`)
+	if n.fail[node] {
+		return nil, []byte("Node - " + node + "\r\nERROR:\r\nCode = 0x800706ba\r\nDescription = The RPC server is unavailable.\r\nFacility = Win32\r\n"), errors.New("exit status 2147944122")
+	}
+	return []byte("\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\n"), nil, nil
+}
+
+type nodeService struct {
+	Node  string `wmi:"__node"`
+	Name  string
+	State string
+}
+
+func TestQueryNodesConcurrent(t *testing.T) {
+
+	r := &nodeRunner{fail: map[string]bool{"SERVER3": true}}
+	out := []*nodeService{}
+	var mu sync.Mutex
+	var args []string
+	_, err := QueryWith("Win32_Service", &out, WithRunner(r), WithNode("SERVER1", "SERVER2", "SERVER3", "SERVER4"),
+		WithParallelism(2), WithDebug(func(a []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			args = append(args, a...)
+		}))
+
+	var nodeErrors NodeErrors
+	if !errors.As(err, &nodeErrors) || len(nodeErrors) != 1 || nodeErrors[0].Node != "SERVER3" {
+		t.Fatalf("expected an error for SERVER3 only, got %v", err)
+	}
+	if !errors.Is(err, ErrRPCUnavailable) {
+		t.Fatalf("expected the node error to match ErrRPCUnavailable")
+	}
+	if len(out) != 3 || out[0].Node != "SERVER1" || out[1].Node != "SERVER2" || out[2].Node != "SERVER4" || out[2].Name != "Spooler" {
+		t.Fatalf("expected the records from the other nodes in order, got %v", out)
+	}
+	if r.max != 2 {
+		t.Fatalf("expected 2 nodes to be queried at once, got %d", r.max)
+	}
+	if strings.Contains(strings.Join(args, " "), "__node") {
+		t.Fatalf("expected __node not to be queried, got %v", args)
+	}
+
+	single := []nodeService{}
+	_, err = QueryWith("Win32_Service", &single, WithRunner(r), WithNode("SERVER1"))
+	if err != nil || len(single) != 1 || single[0].Node != "SERVER1" {
+		t.Fatalf("expected the node to be set for a single node, got %v %v", single, err)
+	}
+
+}
+
+func TestQueryNodeFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	err := os.WriteFile(path, []byte("# file servers\r\nSERVER1\r\n\r\n  SERVER2  \n#SERVER3\nSERVER4\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &nodeRunner{fail: map[string]bool{"SERVER4": true}}
+	out := []nodeService{}
+	_, err = QueryNodeFile(path, "Win32_Service", &out, WithRunner(r))
+	var nodeErrors NodeErrors
+	if !errors.As(err, &nodeErrors) || len(nodeErrors) != 1 || nodeErrors[0].Node != "SERVER4" {
+		t.Fatalf("expected an error for SERVER4 only, got %v", err)
+	}
+	if len(out) != 2 || out[0].Node != "SERVER1" || out[1].Node != "SERVER2" {
+		t.Fatalf("expected the records from the listed nodes, got %v", out)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	os.WriteFile(empty, []byte("# none yet\n\n"), 0o644)
+	_, err = QueryNodeFile(empty, "Win32_Service", &out, WithRunner(r))
+	if err == nil || !strings.Contains(err.Error(), "No nodes") {
+		t.Fatalf("expected an error for an empty host list, got %v", err)
+	}
+
+	_, err = QueryNodeFile(filepath.Join(t.TempDir(), "missing.txt"), "Win32_Service", &out, WithRunner(r))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file error, got %v", err)
+	}
+
+}
@@ -0,0 +1,477 @@
+package wmic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+// QueryOptions holds the settings for a query built by QueryWith
+type QueryOptions struct {
+	// Timeout for the wmic process, DefaultTimeout is used if zero. Use
+	// WithTimeout(0) for no timeout. A negative timeout is an error
+	Timeout time.Duration
+	// Executable is the path of wmic, found on the PATH if empty
+	Executable string
+	// Nodes are the remote computers to query, the local computer if empty
+	Nodes []string
+	// Alias queries a wmic alias such as CPU or NIC instead of a class
+	Alias bool
+	// Parallelism is the number of nodes queried at once, 4 if zero
+	Parallelism int
+	// User and Password are the credentials for remote nodes. The password is
+	// never included in errors or logged command lines
+	User     string
+	Password string
+	// Namespace is the WMI namespace, wmic uses root\cimv2 if empty
+	Namespace string
+	// NonInteractive adds /INTERACTIVE:OFF so wmic never prompts for
+	// confirmation, which would hang an unattended query. /INTERACTIVE has
+	// been supported since wmic was added in Windows XP
+	NonInteractive bool
+	// FailFast adds /FAILFAST:ON so wmic checks that a node responds before
+	// querying it, and an unreachable node fails quickly instead of after the
+	// connection timeout. Some early versions of wmic don't support
+	// /FAILFAST. If wmic reports either switch is invalid the query is run
+	// again without them
+	FailFast bool
+	// Columns to GET, built from the out struct if empty. AllColumns gets every
+	// property
+	Columns []string
+	// OrderBy sorts the results by property, e.g. "Name ASC"
+	OrderBy []string
+	// Append adds the records to those already in the out slice instead of
+	// replacing them, e.g. to collect the results of several queries. The
+	// slice is unchanged if the query returns any error, including NodeErrors
+	// or a failure to sort, and OrderBy only sorts the appended records
+	Append bool
+	// Lenient returns missing fields and unsupported types as RecordErrors
+	// instead of failing the query
+	Lenient bool
+	// JSONStrings keeps every value as a JSON string in QueryJSON instead of
+	// writing numbers as JSON numbers
+	JSONStrings bool
+	// IgnoreMissing leaves fields at their zero value if the class doesn't
+	// have the property, so one struct can be used for several class versions
+	IgnoreMissing bool
+	// IgnoreCase matches properties to struct fields and tags without regard
+	// to case, e.g. ProcessID to ProcessId. The GET list still uses the
+	// field names
+	IgnoreCase bool
+	// DecimalSeparator is the decimal mark floats are formatted with, e.g. ","
+	// for locales that format pi as 3,14. A period is used if empty
+	DecimalSeparator string
+	// GroupSeparator is removed from numbers before they are parsed, e.g. ","
+	// for sizes formatted as 1,048,576. String fields are unchanged
+	GroupSeparator string
+	// WrapNegative reinterprets negative values of unsigned fields with two's
+	// complement at the size of the field, e.g. -1 is 4294967295 for a uint32,
+	// for properties some systems print as signed. They are errors if false
+	WrapNegative bool
+	// Encoding is the code page of the wmic output. If nil output that isn't
+	// valid UTF-8 is decoded with the console code page
+	Encoding encoding.Encoding
+	// Format is the wmic output format to request and parse
+	Format Format
+	// Decoder parses the wmic output into the out slice pointer instead of the
+	// built-in parser, for output it can't handle. It isn't used by QueryIter,
+	// which streams the output, or the PowerShell backend, which outputs JSON
+	Decoder func(stdout []byte, out interface{}) ([]RecordError, error)
+	// Backend is the command used to query WMI
+	Backend Backend
+	// Debug is called with the wmic arguments before each run, with any
+	// password redacted. It is called concurrently when querying several nodes
+	Debug func(args []string)
+	// OnComplete is called after each query with how long it took, the number
+	// of records returned and the error if it failed
+	OnComplete func(class string, duration time.Duration, rows int, err error)
+	// Logger logs each command run, nothing is logged if nil
+	Logger Logger
+	// Runner runs wmic, DefaultRunner is used if nil
+	Runner Runner
+	// Attempts is the maximum number of times to run the query, once if zero
+	Attempts int
+	// AttemptTimeout limits each attempt when retrying, so a hung attempt is
+	// retried within the overall Timeout. Attempts aren't limited if zero
+	AttemptTimeout time.Duration
+	// Backoff is the wait before the first retry, doubled for each retry after
+	Backoff time.Duration
+	// RetryOn are the errors that are retried, ErrRPCUnavailable if nil
+	RetryOn []error
+	// OnRetry is called with the attempt number and error before each retry
+	OnRetry func(attempt int, err error)
+	// ClassPrefix limits the classes ListClasses returns to those with names
+	// starting with it, ignoring case
+	ClassPrefix string
+	// Where clause, passed to wmic as is without any quoting or escaping. Use
+	// WithCondition to build one from untrusted values
+	Where string
+	// associators queries the instances associated with those of the class,
+	// of resultClass if set
+	associators bool
+	resultClass string
+	// wql is the query PowerShell runs with -Query instead of building one
+	// from the class, columns and where clause
+	wql string
+	// noTimeout is set by WithTimeout(0)
+	noTimeout bool
+	// client is the context of the Client running the query, which is done
+	// when the client is closed
+	client context.Context
+}
+
+// Option sets a field on QueryOptions
+type Option func(*QueryOptions)
+
+// WithTimeout sets the timeout for the wmic process. Zero means no timeout,
+// e.g. for long enumerations that shouldn't be stopped by DefaultTimeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *QueryOptions) {
+		o.Timeout = timeout
+		o.noTimeout = timeout == 0
+	}
+}
+
+// WithExecutable runs wmic from the path, e.g. a copy outside the PATH
+func WithExecutable(path string) Option {
+	return func(o *QueryOptions) {
+		o.Executable = path
+	}
+}
+
+// WithNode queries one or more remote computers by hostname or IP address.
+// Several nodes are queried concurrently and the results concatenated into
+// the out slice in node order. A string field tagged wmi:"__node" is set to
+// the node each record came from
+func WithNode(nodes ...string) Option {
+	return func(o *QueryOptions) {
+		o.Nodes = nodes
+	}
+}
+
+// WithAlias treats the class as a wmic alias such as CPU, BIOS, OS, DISKDRIVE
+// or NIC, so wmic is run as "wmic cpu get ..." instead of "wmic PATH class get
+// ...". The PowerShell backend queries the class the alias is for
+func WithAlias() Option {
+	return func(o *QueryOptions) {
+		o.Alias = true
+	}
+}
+
+// WithParallelism sets the number of nodes queried at once
+func WithParallelism(n int) Option {
+	return func(o *QueryOptions) {
+		o.Parallelism = n
+	}
+}
+
+// WithCredentials authenticates as the user, e.g. domain\user, on remote nodes
+func WithCredentials(user, password string) Option {
+	return func(o *QueryOptions) {
+		o.User = user
+		o.Password = password
+	}
+}
+
+// WithNamespace queries a WMI namespace other than the default, e.g. root\wmi
+// for MSStorageDriver_FailurePredictStatus
+func WithNamespace(namespace string) Option {
+	return func(o *QueryOptions) {
+		o.Namespace = namespace
+	}
+}
+
+// WithNonInteractive stops wmic prompting for confirmation with
+// /INTERACTIVE:OFF
+func WithNonInteractive() Option {
+	return func(o *QueryOptions) {
+		o.NonInteractive = true
+	}
+}
+
+// WithFailFast makes wmic fail quickly for unreachable nodes with
+// /FAILFAST:ON, it is left out for versions of wmic without it
+func WithFailFast() Option {
+	return func(o *QueryOptions) {
+		o.FailFast = true
+	}
+}
+
+// WithColumns sets the columns to GET instead of the out struct fields
+func WithColumns(columns ...string) Option {
+	return func(o *QueryOptions) {
+		o.Columns = columns
+	}
+}
+
+// WithAllColumns gets every property of the class. Properties without a
+// struct field fail the query unless WithLenient or WithIgnoreMissing is set
+func WithAllColumns() Option {
+	return WithColumns(AllColumns)
+}
+
+// WithWhere sets a raw where clause, values are not quoted or escaped
+func WithWhere(where string) Option {
+	return func(o *QueryOptions) {
+		o.Where = where
+	}
+}
+
+// WithCondition sets the where clause from a condition built with Where, Eq,
+// And, Or etc.
+func WithCondition(c Condition) Option {
+	return func(o *QueryOptions) {
+		o.Where = c.String()
+	}
+}
+
+// WithOrderBy sorts the results by one or more properties, each optionally
+// followed by ASC or DESC, e.g. WithOrderBy("Name ASC", "ProcessId DESC").
+// wmic can't sort so this is done after the results are decoded
+func WithOrderBy(properties ...string) Option {
+	return func(o *QueryOptions) {
+		o.OrderBy = properties
+	}
+}
+
+// WithClassPrefix makes ListClasses only return the classes with names
+// starting with prefix, e.g. Win32_
+func WithClassPrefix(prefix string) Option {
+	return func(o *QueryOptions) {
+		o.ClassPrefix = prefix
+	}
+}
+
+// WithAppend adds the records to those already in the out slice instead of
+// replacing them
+func WithAppend() Option {
+	return func(o *QueryOptions) {
+		o.Append = true
+	}
+}
+
+// WithJSONStrings keeps every value as a string in QueryJSON
+func WithJSONStrings() Option {
+	return func(o *QueryOptions) {
+		o.JSONStrings = true
+	}
+}
+
+// WithLenient continues decoding when a property has no matching field or the
+// field type isn't supported, returning these as RecordErrors
+func WithLenient() Option {
+	return func(o *QueryOptions) {
+		o.Lenient = true
+	}
+}
+
+// WithIgnoreMissing leaves struct fields that aren't properties of the class
+// at their zero value. If wmic rejects the GET list all the properties are
+// requested instead and those without a field are ignored
+func WithIgnoreMissing() Option {
+	return func(o *QueryOptions) {
+		o.IgnoreMissing = true
+	}
+}
+
+// WithIgnoreCase matches properties in the output to struct fields and tags
+// without regard to case
+func WithIgnoreCase() Option {
+	return func(o *QueryOptions) {
+		o.IgnoreCase = true
+	}
+}
+
+// WithDecimalSeparator parses floats formatted with the decimal separator, e.g.
+// "," for 1.234,56
+func WithDecimalSeparator(sep string) Option {
+	return func(o *QueryOptions) {
+		o.DecimalSeparator = sep
+	}
+}
+
+// WithGroupSeparator removes the digit group separator from numbers before
+// they are parsed, e.g. "," for 1,048,576. Only number fields are affected so
+// strings containing the separator are unchanged
+func WithGroupSeparator(sep string) Option {
+	return func(o *QueryOptions) {
+		o.GroupSeparator = sep
+	}
+}
+
+// WithWrapNegative reinterprets negative values of unsigned fields with two's
+// complement, e.g. -1 is 4294967295 for a uint32
+func WithWrapNegative() Option {
+	return func(o *QueryOptions) {
+		o.WrapNegative = true
+	}
+}
+
+// WithEncoding decodes the wmic output with the encoding, e.g.
+// charmap.Windows1252 or japanese.ShiftJIS from golang.org/x/text
+func WithEncoding(e encoding.Encoding) Option {
+	return func(o *QueryOptions) {
+		o.Encoding = e
+	}
+}
+
+// WithFormat sets the wmic output format, FormatXML handles multi-line and
+// array values more reliably than the default FormatValue
+func WithFormat(format Format) Option {
+	return func(o *QueryOptions) {
+		o.Format = format
+	}
+}
+
+// WithDecoder parses the wmic output with fn instead of the built-in parser.
+// The command is built and run as usual, fn gets the stdout of a successful
+// run after it is decoded to UTF-8
+func WithDecoder(fn func(stdout []byte, out interface{}) ([]RecordError, error)) Option {
+	return func(o *QueryOptions) {
+		o.Decoder = fn
+	}
+}
+
+// WithBackend sets the command used to query WMI
+func WithBackend(backend Backend) Option {
+	return func(o *QueryOptions) {
+		o.Backend = backend
+	}
+}
+
+// WithDebug calls fn with the wmic arguments before each run, with any
+// password redacted
+func WithDebug(fn func(args []string)) Option {
+	return func(o *QueryOptions) {
+		o.Debug = fn
+	}
+}
+
+// WithOnComplete calls fn after each query with how long it took, the number
+// of records returned and the error if it failed, e.g. to record metrics
+func WithOnComplete(fn func(class string, duration time.Duration, rows int, err error)) Option {
+	return func(o *QueryOptions) {
+		o.OnComplete = fn
+	}
+}
+
+// WithLogger logs each command run by the query with the logger
+func WithLogger(logger Logger) Option {
+	return func(o *QueryOptions) {
+		o.Logger = logger
+	}
+}
+
+// WithRunner runs wmic with the runner instead of DefaultRunner
+func WithRunner(runner Runner) Option {
+	return func(o *QueryOptions) {
+		o.Runner = runner
+	}
+}
+
+// WithRetry runs the query up to attempts times if it fails with a transient
+// error, waiting backoff before the first retry and doubling it for each retry
+// after. Retries stop when the query timeout or context deadline is reached
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *QueryOptions) {
+		o.Attempts = attempts
+		o.Backoff = backoff
+	}
+}
+
+// WithAttemptTimeout limits each attempt when retrying, an attempt that times
+// out is killed and retried
+func WithAttemptTimeout(timeout time.Duration) Option {
+	return func(o *QueryOptions) {
+		o.AttemptTimeout = timeout
+	}
+}
+
+// WithRetryOn sets the errors that are retried, checked with errors.Is
+func WithRetryOn(errs ...error) Option {
+	return func(o *QueryOptions) {
+		o.RetryOn = errs
+	}
+}
+
+// WithOnRetry calls fn with the attempt number and error before each retry
+func WithOnRetry(fn func(attempt int, err error)) Option {
+	return func(o *QueryOptions) {
+		o.OnRetry = fn
+	}
+}
+
+// BuildArgs returns the wmic arguments QueryWith would run for the class and
+// options without running them, with any password redacted
+func BuildArgs(class string, out interface{}, opts ...Option) ([]string, error) {
+	_, innerType, _, err := outSlice(out)
+	if err != nil {
+		return nil, err
+	}
+	return redactArgs(buildArgs(class, innerType, newOptions(opts))), nil
+}
+
+// newOptions applies the options to the defaults
+func newOptions(opts []Option) *QueryOptions {
+	o := &QueryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// QueryWith returns a WMI query for the class configured by the options
+func QueryWith(class string, out interface{}, opts ...Option) ([]RecordError, error) {
+	return QueryWithContext(context.Background(), class, out, opts...)
+}
+
+// QueryWithContext is QueryWith with a context, the query stops when the
+// context is done or the timeout is reached, whichever is first. Retries and
+// the attempt timeout are within the same limit
+func QueryWithContext(ctx context.Context, class string, out interface{}, opts ...Option) ([]RecordError, error) {
+	o := newOptions(opts)
+
+	ctx, cancel, err := withTimeout(ctx, o.timeout())
+	if err != nil {
+		return []RecordError{}, err
+	}
+	defer cancel()
+
+	return query(ctx, class, out, o)
+}
+
+// timeout returns the timeout for the query, DefaultTimeout if it isn't set
+// or 0 for no timeout
+func (o *QueryOptions) timeout() time.Duration {
+	if o.noTimeout {
+		return 0
+	}
+	if o.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return o.Timeout
+}
+
+// checkTimeout returns an error if the timeout is negative
+func checkTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("Timeout %s is negative, use 0 for no timeout", timeout)
+	}
+	return nil
+}
+
+// withTimeout returns a context that is done after the timeout, or only when
+// ctx is done if the timeout is zero. A negative timeout is an error
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, error) {
+	if err := checkTimeout(timeout); err != nil {
+		return nil, nil, err
+	}
+	if timeout == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
@@ -0,0 +1,362 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+
+	o := &QueryOptions{}
+	for _, opt := range []Option{WithTimeout(time.Second), WithNode("SERVER1"), WithNamespace(`\\root\wmi`), WithColumns("Name"), WithWhere("State='Running'")} {
+		opt(o)
+	}
+	if o.Timeout != time.Second || len(o.Nodes) != 1 || o.Namespace != `\\root\wmi` || len(o.Columns) != 1 || o.Where != "State='Running'" {
+		t.Fatalf("options not applied: %+v", o)
+	}
+	got := strings.Join(buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), o), " ")
+	if !strings.HasPrefix(got, `/NODE:"SERVER1" /NAMESPACE:\\root\wmi PATH Win32_Service WHERE ( State='Running' ) GET Name `) {
+		t.Fatalf("unexpected args %s", got)
+	}
+
+}
+
+func TestCredentials(t *testing.T) {
+
+	o := &QueryOptions{}
+	WithNode("SERVER1")(o)
+	WithCredentials(`DOMAIN\admin`, "s3cret")(o)
+	args := buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), o)
+	got := strings.Join(args, " ")
+	if !strings.HasPrefix(got, `/NODE:"SERVER1" /USER:"DOMAIN\admin" /PASSWORD:"s3cret" PATH `) {
+		t.Fatalf("credentials not added before PATH: %s", got)
+	}
+	redacted := strings.Join(redactArgs(args), " ")
+	if strings.Contains(redacted, "s3cret") || !strings.Contains(redacted, "/PASSWORD:") {
+		t.Fatalf("password not redacted: %s", redacted)
+	}
+
+}
+
+func TestNamespace(t *testing.T) {
+
+	for _, ns := range []string{`root\wmi`, `\\root\wmi`, "root/wmi"} {
+		if got := formatNamespace(ns); got != `\\root\wmi` {
+			t.Errorf("formatNamespace(%s) = %s", ns, got)
+		}
+		if err := checkNamespace(ns); err != nil {
+			t.Errorf("unexpected error for %s: %s", ns, err)
+		}
+	}
+	for _, ns := range []string{`root\wmi" & calc`, "root\\wmi|x", "root wmi"} {
+		if err := checkNamespace(ns); err == nil {
+			t.Errorf("expected error for %s", ns)
+		}
+	}
+
+}
+
+func TestBuildArgsAndDebug(t *testing.T) {
+
+	out := []win32Service{}
+	opts := []Option{WithColumns("Name"), WithCredentials("admin", "s3cret"), WithNode("SERVER1")}
+	args, err := BuildArgs("Win32_Service", &out, opts...)
+	if err != nil {
+		t.Fatalf("BuildArgs failed: %s", err)
+	}
+	got := strings.Join(args, " ")
+	if !strings.Contains(got, "PATH Win32_Service GET Name") || strings.Contains(got, "s3cret") {
+		t.Fatalf("unexpected args %s", got)
+	}
+
+	var debugged []string
+	f := &fakeRunner{stdout: "Name=Spooler\r\r\n\r\r\n"}
+	opts = append(opts, WithRunner(f), WithDebug(func(args []string) { debugged = args }))
+	_, err = QueryWith("Win32_Service", &out, opts...)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if strings.Join(debugged, " ") != got {
+		t.Fatalf("debug args %v don't match %v", debugged, args)
+	}
+	if !strings.Contains(strings.Join(f.args[0], " "), "s3cret") {
+		t.Fatalf("expected the password in the executed args")
+	}
+
+}
+
+// hangRunner blocks until the query is cancelled, like a hung wmic process
+type hangRunner struct{}
+
+func (hangRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+func TestDefaultTimeout(t *testing.T) {
+
+	old := DefaultTimeout
+	DefaultTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { DefaultTimeout = old })
+	useRunner(t, hangRunner{})
+
+	start := time.Now()
+	out := []win32Service{}
+	_, err := QueryAll("Win32_Service", &out)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the default timeout to be reached, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the query to stop after the default timeout, took %s", elapsed)
+	}
+
+}
+
+func TestQueryWithDuration(t *testing.T) {
+
+	useRunner(t, hangRunner{})
+	out := []win32Service{}
+	_, err := QueryAllWithDuration("Win32_Service", &out, 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the timeout to be reached, got %v", err)
+	}
+
+	_, err = QueryAllWithTimeout("Win32_Service", &out, "10")
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected an invalid duration error, got %v", err)
+	}
+
+}
+
+// deadlineRunner records whether the context of each run had a deadline
+type deadlineRunner struct {
+	deadlines []bool
+}
+
+func (d *deadlineRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	_, ok := ctx.Deadline()
+	d.deadlines = append(d.deadlines, ok)
+	return []byte(serviceOutput), nil, nil
+}
+
+func TestNoTimeout(t *testing.T) {
+
+	d := &deadlineRunner{}
+	out := []win32Service{}
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(d), WithTimeout(0)); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(d)); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	useRunner(t, d)
+	if _, err := QueryAllWithTimeout("Win32_Service", &out, "0"); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	client := NewClient(WithTimeout(time.Minute))
+	if _, err := client.QueryWith("Win32_Service", &out, WithTimeout(0)); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	old := DefaultTimeout
+	DefaultTimeout = 0
+	t.Cleanup(func() { DefaultTimeout = old })
+	if _, err := QueryAll("Win32_Service", &out); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if want := []bool{false, true, false, false, false}; !reflect.DeepEqual(d.deadlines, want) {
+		t.Fatalf("expected deadlines %v, got %v", want, d.deadlines)
+	}
+
+	// A query without a timeout still stops when the context is done
+	useRunner(t, hangRunner{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := QueryWithContext(ctx, "Win32_Service", &out, WithTimeout(0)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context deadline, got %v", err)
+	}
+
+}
+
+func TestNegativeTimeout(t *testing.T) {
+
+	d := &deadlineRunner{}
+	useRunner(t, d)
+	out := []win32Service{}
+	if _, err := QueryAllWithTimeout("Win32_Service", &out, "-1s"); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Fatalf("expected a negative timeout error, got %v", err)
+	}
+	if _, err := Watch[win32Service](context.Background(), "Win32_Service", nil, "", time.Second, WithTimeout(-time.Second)); err == nil {
+		t.Fatal("expected Watch to reject the negative timeout")
+	}
+	old := DefaultTimeout
+	DefaultTimeout = -time.Minute
+	t.Cleanup(func() { DefaultTimeout = old })
+	if _, err := Exists("Win32_Service", ""); err == nil {
+		t.Fatal("expected a negative default timeout error")
+	}
+	if len(d.deadlines) != 0 {
+		t.Fatalf("expected nothing to run, ran %d queries", len(d.deadlines))
+	}
+
+}
+
+func TestAlias(t *testing.T) {
+
+	type cpu struct {
+		Name          string
+		NumberOfCores int
+	}
+	f := &fakeRunner{stdout: "\r\r\nName=Intel(R) Core(TM) i7-8650U CPU @ 1.90GHz\r\r\nNumberOfCores=4\r\r\n\r\r\n"}
+	out := []cpu{}
+	_, err := QueryWith("cpu", &out, WithRunner(f), WithAlias())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 1 || out[0].NumberOfCores != 4 {
+		t.Fatalf("unexpected processors %v", out)
+	}
+	if got := strings.Join(f.args[0], " "); got != "cpu GET Name,NumberOfCores /VALUE" {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+
+	f = &fakeRunner{stdout: `[{"Name":"Intel","NumberOfCores":4}]`}
+	_, err = QueryWith("cpu", &out, WithRunner(f), WithAlias(), WithBackend(BackendPowerShell))
+	if err != nil || !strings.Contains(f.args[0][len(f.args[0])-1], "-ClassName 'Win32_Processor'") {
+		t.Fatalf("expected the alias class to be queried, got %v %v", f.args, err)
+	}
+
+	_, err = QueryWith("sysdriver", &out, WithRunner(f), WithAlias(), WithBackend(BackendPowerShell))
+	if err == nil {
+		t.Fatalf("expected an error for an unknown alias")
+	}
+
+}
+
+func TestOnComplete(t *testing.T) {
+
+	var classes []string
+	var rows []int
+	var errs []error
+	onComplete := WithOnComplete(func(class string, duration time.Duration, n int, err error) {
+		if duration <= 0 {
+			t.Errorf("expected a duration for %s", class)
+		}
+		classes = append(classes, class)
+		rows = append(rows, n)
+		errs = append(errs, err)
+	})
+
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(&fakeRunner{stdout: serviceOutput}), onComplete)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	_, err = QueryWith("Win32_Servic", &out, WithRunner(&fakeRunner{stderr: "ERROR:\r\nDescription = Invalid class \r\n", err: errors.New("exit status 44135")}), onComplete)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid class")
+	}
+
+	if !reflect.DeepEqual(classes, []string{"Win32_Service", "Win32_Servic"}) || !reflect.DeepEqual(rows, []int{2, 0}) {
+		t.Fatalf("unexpected calls %v %v", classes, rows)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], ErrInvalidClass) {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+}
+
+func TestAllColumns(t *testing.T) {
+
+	args, err := BuildArgs("Win32_Service", &[]win32Service{}, WithAllColumns())
+	if err != nil {
+		t.Fatalf("build failed: %s", err)
+	}
+	if got := strings.Join(args, " "); got != "PATH Win32_Service GET /VALUE" {
+		t.Fatalf("expected no GET list, got %s", got)
+	}
+
+}
+
+func TestDecoder(t *testing.T) {
+
+	f := &fakeRunner{stdout: "Spooler|Running\r\nW32Time|Stopped\r\n"}
+	out := []win32Service{}
+	errs, err := QueryWith("Win32_Service", &out, WithRunner(f), WithNode("server1"), WithDecoder(func(stdout []byte, out interface{}) ([]RecordError, error) {
+		services := out.(*[]win32Service)
+		for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\r\n") {
+			parts := strings.Split(line, "|")
+			*services = append(*services, win32Service{Name: parts[0], State: parts[1]})
+		}
+		return []RecordError{{Class: "Win32_Service", Record: 2, Message: "custom"}}, nil
+	}))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(f.args) != 1 || !strings.HasPrefix(strings.Join(f.args[0], " "), `/NODE:"server1" PATH Win32_Service`) {
+		t.Fatalf("expected the usual command, got %q", f.args)
+	}
+	if len(out) != 2 || out[1].Name != "W32Time" || out[1].State != "Stopped" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(errs) != 1 || errs[0].Message != "custom" {
+		t.Fatalf("expected the decoder's record errors, got %+v", errs)
+	}
+
+	decodeErr := errors.New("bad output")
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f), WithDecoder(func(stdout []byte, out interface{}) ([]RecordError, error) {
+		return []RecordError{}, decodeErr
+	}))
+	if !errors.Is(err, decodeErr) {
+		t.Fatalf("expected the decoder's error, got %v", err)
+	}
+
+}
+
+func TestAppend(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{{Name: "Existing"}}
+	rows := 0
+	onComplete := WithOnComplete(func(class string, duration time.Duration, n int, err error) {
+		rows = n
+	})
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithAppend(), WithOrderBy("Name DESC"), onComplete)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 3 || out[0].Name != "Existing" || out[1].Name != "W32Time" || out[2].Name != "Spooler" {
+		t.Fatalf("expected the sorted records appended, got %+v", out)
+	}
+	if rows != 2 {
+		t.Fatalf("expected OnComplete to count the appended records, got %d", rows)
+	}
+
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f), WithAppend(), WithNode("a", "b"))
+	if err != nil || len(out) != 7 {
+		t.Fatalf("expected the records of both nodes appended, got %d %v", len(out), err)
+	}
+
+	f.err = errors.New("exit status 1")
+	f.stderr = "ERROR:\r\nDescription = Invalid class.\r\n"
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f), WithAppend())
+	if err == nil || len(out) != 7 {
+		t.Fatalf("expected the records kept after the failure, got %d %v", len(out), err)
+	}
+
+	f.err, f.stderr = nil, ""
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f), WithAppend(), WithOrderBy("Missing"))
+	if err == nil || len(out) != 7 {
+		t.Fatalf("expected the records kept after the sort failed, got %d %v", len(out), err)
+	}
+
+	// Without the option the records are replaced
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f))
+	if err != nil || len(out) != 2 {
+		t.Fatalf("expected the records replaced, got %d %v", len(out), err)
+	}
+
+}
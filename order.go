@@ -0,0 +1,151 @@
+package wmic
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortKey is a property to sort by
+type sortKey struct {
+	index []int
+	desc  bool
+}
+
+// sortRecords sorts the out slice by the order by properties. Records with a
+// nil pointer for a property are sorted last regardless of direction
+func sortRecords(outerValue reflect.Value, orderBy []string) error {
+	if len(orderBy) == 0 || outerValue.Len() < 2 {
+		return nil
+	}
+
+	innerType := outerValue.Type().Elem()
+	if innerType.Kind() == reflect.Ptr {
+		innerType = innerType.Elem()
+	}
+
+	keys := []sortKey{}
+	for _, o := range orderBy {
+		parts := strings.Fields(o)
+		if len(parts) == 0 || len(parts) > 2 {
+			return fmt.Errorf("Invalid order by %s", o)
+		}
+		key := sortKey{}
+		if len(parts) == 2 {
+			switch strings.ToUpper(parts[1]) {
+			case "ASC":
+			case "DESC":
+				key.desc = true
+			default:
+				return fmt.Errorf("Invalid order by direction %s", parts[1])
+			}
+		}
+		f, ok := structFieldByProperty(innerType, parts[0])
+		if !ok {
+			return &FieldError{Field: parts[0]}
+		}
+		t := f.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if !sortable(t) {
+			return &UnsupportedTypeError{Field: parts[0], Type: t.Kind().String()}
+		}
+		key.index = f.Index
+		keys = append(keys, key)
+	}
+
+	elem := func(i int) reflect.Value {
+		v := outerValue.Index(i)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		return v
+	}
+	sort.SliceStable(outerValue.Interface(), func(i, j int) bool {
+		a, b := elem(i), elem(j)
+		for _, key := range keys {
+			c := compareField(fieldByIndex(a, key.index, false), fieldByIndex(b, key.index, false), key.desc)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// structFieldByProperty finds the struct field for a WMI property name
+func structFieldByProperty(t reflect.Type, property string) (reflect.StructField, bool) {
+	for _, p := range structProperties(t) {
+		if !p.skip && p.name == property {
+			return p.field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortable(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// compareField returns -1, 0 or 1 comparing two field values. Nil pointers
+// always compare after non-nil values
+func compareField(a, b reflect.Value, desc bool) int {
+	if a.Kind() == reflect.Ptr {
+		switch {
+		case a.IsNil() && b.IsNil():
+			return 0
+		case a.IsNil():
+			return 1
+		case b.IsNil():
+			return -1
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	c := compareValue(a, b)
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func compareValue(a, b reflect.Value) int {
+	var less, greater bool
+	if a.Type() == timeType {
+		ta, tb := a.Interface().(time.Time), b.Interface().(time.Time)
+		less, greater = ta.Before(tb), ta.After(tb)
+	} else {
+		switch a.Kind() {
+		case reflect.String:
+			less, greater = a.String() < b.String(), a.String() > b.String()
+		case reflect.Bool:
+			less, greater = !a.Bool() && b.Bool(), a.Bool() && !b.Bool()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			less, greater = a.Int() < b.Int(), a.Int() > b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			less, greater = a.Uint() < b.Uint(), a.Uint() > b.Uint()
+		case reflect.Float32, reflect.Float64:
+			less, greater = a.Float() < b.Float(), a.Float() > b.Float()
+		}
+	}
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package wmic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type orderResult struct {
+	Name      string
+	ProcessId int
+	Priority  *uint32
+	Created   time.Time
+}
+
+func TestSortRecords(t *testing.T) {
+
+	p := func(n uint32) *uint32 { return &n }
+	now := time.Now()
+	out := []*orderResult{
+		{Name: "b", ProcessId: 1, Created: now},
+		{Name: "a", ProcessId: 2, Priority: p(8), Created: now.Add(time.Hour)},
+		{Name: "b", ProcessId: 3, Priority: p(4), Created: now.Add(-time.Hour)},
+	}
+
+	orders := []struct {
+		orderBy []string
+		want    []int
+	}{
+		{[]string{"Name ASC", "ProcessId DESC"}, []int{2, 3, 1}},
+		{[]string{"Priority"}, []int{3, 2, 1}},
+		{[]string{"Priority DESC"}, []int{2, 3, 1}},
+		{[]string{"Created desc"}, []int{2, 1, 3}},
+	}
+	for _, o := range orders {
+		err := sortRecords(reflect.ValueOf(out), o.orderBy)
+		if err != nil {
+			t.Fatalf("sort failed: %s", err)
+		}
+		for i, id := range o.want {
+			if out[i].ProcessId != id {
+				t.Errorf("%v: position %v got %v, want %v", o.orderBy, i, out[i].ProcessId, id)
+			}
+		}
+	}
+
+	err := sortRecords(reflect.ValueOf(out), []string{"Missing"})
+	if _, ok := err.(*FieldError); !ok {
+		t.Errorf("expected FieldError, got %v", err)
+	}
+
+}
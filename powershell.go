@@ -0,0 +1,263 @@
+package wmic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Backend is the command used to query WMI
+type Backend int
+
+const (
+	// BackendAuto uses wmic, falling back to PowerShell if wmic isn't installed
+	BackendAuto Backend = iota
+	// BackendWMIC only uses wmic
+	BackendWMIC
+	// BackendPowerShell uses the Get-CimInstance PowerShell cmdlet, for versions
+	// of Windows where wmic has been removed
+	BackendPowerShell
+)
+
+// aliases maps the common wmic aliases to their classes for the PowerShell
+// backend
+var aliases = map[string]string{
+	"BASEBOARD":      "Win32_BaseBoard",
+	"BIOS":           "Win32_BIOS",
+	"COMPUTERSYSTEM": "Win32_ComputerSystem",
+	"CPU":            "Win32_Processor",
+	"DISKDRIVE":      "Win32_DiskDrive",
+	"ENVIRONMENT":    "Win32_Environment",
+	"LOGICALDISK":    "Win32_LogicalDisk",
+	"MEMORYCHIP":     "Win32_PhysicalMemory",
+	"NIC":            "Win32_NetworkAdapter",
+	"NICCONFIG":      "Win32_NetworkAdapterConfiguration",
+	"OS":             "Win32_OperatingSystem",
+	"PARTITION":      "Win32_DiskPartition",
+	"PROCESS":        "Win32_Process",
+	"QFE":            "Win32_QuickFixEngineering",
+	"SERVICE":        "Win32_Service",
+	"SHARE":          "Win32_Share",
+	"USERACCOUNT":    "Win32_UserAccount",
+	"VOLUME":         "Win32_Volume",
+}
+
+// runPowerShell runs the query with Get-CimInstance and decodes the JSON
+// output into out
+func runPowerShell(ctx context.Context, class string, out interface{}, innerType reflect.Type, o *QueryOptions) ([]RecordError, error) {
+	args, err := powerShellArgs(class, innerType, o)
+	if err != nil {
+		return []RecordError{}, err
+	}
+
+	stdout, stderr, err := o.runner().Run(ctx, "powershell", args)
+	stdout, stderr = transcode(stdout, o.Encoding), transcode(stderr, o.Encoding)
+	err = cmdError(err, stdout, stderr)
+	if err != nil {
+		return []RecordError{}, err
+	}
+
+	return decodeRecords(newJSONReader(bytes.NewReader(stdout)), class, out, o)
+}
+
+// powerShellArgs returns the powershell arguments for a query. Datetimes are
+// converted to CIM_DATETIME strings so they decode the same as wmic output
+func powerShellArgs(class string, innerType reflect.Type, o *QueryOptions) ([]string, error) {
+	if o.User != "" {
+		return nil, errors.New("Credentials are not supported by the PowerShell backend")
+	}
+
+	if o.Alias && o.wql == "" {
+		aliasClass, ok := aliases[strings.ToUpper(class)]
+		if !ok {
+			return nil, fmt.Errorf("Alias %s is not supported by the PowerShell backend", class)
+		}
+		class = aliasClass
+	}
+
+	where := o.Where
+	if i := strings.IndexByte(class, '.'); i > 0 && o.wql == "" {
+		// An object path, get the class filtered by the key properties
+		class, where = class[:i], pathFilter(class[i+1:])
+	}
+
+	list := getList(innerType, o.Columns)
+	properties := []string{}
+	for _, p := range strings.Split(list, ",") {
+		properties = append(properties, psQuote(p))
+	}
+	propertyList := strings.Join(properties, ",")
+	selectList := propertyList
+	if list == AllColumns {
+		// Leave out the CIM metadata Select-Object adds to the properties
+		selectList = "* -ExcludeProperty 'CimClass','CimInstanceProperties','CimSystemProperties','PSComputerName'"
+	}
+
+	get := []string{"Get-CimInstance", "-ClassName", psQuote(class)}
+	if o.wql != "" {
+		// The query selects the properties
+		get = []string{"Get-CimInstance", "-Query", psQuote(o.wql)}
+		where = ""
+	} else if !o.associators && list != AllColumns {
+		get = append(get, "-Property", propertyList)
+	}
+	if o.Namespace != "" {
+		get = append(get, "-Namespace", psQuote(strings.TrimLeft(formatNamespace(o.Namespace), `\`)))
+	}
+	if strings.TrimSpace(where) != "" {
+		get = append(get, "-Filter", psQuote(where))
+	}
+	if len(o.Nodes) > 0 {
+		nodes := []string{}
+		for _, n := range o.Nodes {
+			nodes = append(nodes, psQuote(n))
+		}
+		get = append(get, "-ComputerName", strings.Join(nodes, ","))
+	}
+	if o.associators {
+		get = append(get, "|", "Get-CimAssociatedInstance")
+		if o.resultClass != "" {
+			get = append(get, "-ResultClassName", psQuote(o.resultClass))
+		}
+	}
+
+	script := "$r = " + strings.Join(get, " ") + " | Select-Object -Property " + selectList + "; " +
+		"foreach ($i in $r) { foreach ($p in $i.PSObject.Properties) { " +
+		"if ($p.Value -is [datetime]) { $p.Value = [Management.ManagementDateTimeConverter]::ToDmtfDateTime($p.Value) } } }; " +
+		"ConvertTo-Json -InputObject @($r) -Compress -Depth 3"
+
+	return []string{"-NoProfile", "-NonInteractive", "-Command", script}, nil
+}
+
+// pathFilter converts the keys of an object path, e.g. Name="a",Id=1, to a
+// filter that matches them
+func pathFilter(keys string) string {
+	conditions := []string{}
+	var condition strings.Builder
+	quoted := false
+	for _, r := range keys {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			conditions = append(conditions, condition.String())
+			condition.Reset()
+			continue
+		}
+		condition.WriteRune(r)
+	}
+	conditions = append(conditions, condition.String())
+	return strings.Join(conditions, " AND ")
+}
+
+// psQuote returns s as a single quoted PowerShell string
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// jsonReader reads records from a JSON array of objects, keeping the order of
+// the properties and formatting the values the way wmic does
+type jsonReader struct {
+	dec     *json.Decoder
+	started bool
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &jsonReader{dec: dec}
+}
+
+func (jr *jsonReader) next() ([]property, error) {
+	if !jr.started {
+		jr.started = true
+		t, err := jr.dec.Token()
+		if err == io.EOF {
+			// No output for no instances
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return nil, fmt.Errorf("Expected a JSON array from PowerShell")
+		}
+	}
+	if !jr.dec.More() {
+		return nil, io.EOF
+	}
+
+	t, err := jr.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("Expected a JSON object from PowerShell")
+	}
+	props := []property{}
+	for jr.dec.More() {
+		t, err := jr.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := t.(string)
+		var v interface{}
+		err = jr.dec.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, property{name: name, value: jsonValue(v), elems: jsonElems(v)})
+	}
+	_, err = jr.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// jsonElems returns the elements of a JSON array, or nil if v isn't an array
+func jsonElems(v interface{}) []string {
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	elems := make([]string, len(a))
+	for i, e := range a {
+		elems[i] = jsonValue(e)
+	}
+	return elems
+}
+
+// jsonValue formats a JSON value as wmic would print it
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case json.Number:
+		return v.String()
+	case []interface{}:
+		elems := []string{}
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				elems = append(elems, `"`+s+`"`)
+			} else {
+				elems = append(elems, jsonValue(e))
+			}
+		}
+		return "{" + strings.Join(elems, ",") + "}"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
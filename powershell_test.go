@@ -0,0 +1,178 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type psResult struct {
+	Name        string
+	ProcessId   uint32
+	Started     bool
+	IPAddress   []string
+	CreatedDate time.Time `wmi:"CreationDate"`
+}
+
+// backendRunner fails wmic as if it isn't installed and returns canned
+// PowerShell output
+type backendRunner struct {
+	stdout string
+	names  []string
+	args   [][]string
+}
+
+func (b *backendRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	b.names = append(b.names, name)
+	b.args = append(b.args, args)
+	if name == "wmic" {
+		return nil, nil, &exec.Error{Name: "wmic", Err: exec.ErrNotFound}
+	}
+	return []byte(b.stdout), nil, nil
+}
+
+func TestPowerShellFallback(t *testing.T) {
+
+	b := &backendRunner{stdout: `[{"Name":"svchost.exe","ProcessId":1068,"Started":true,"IPAddress":["10.0.0.1","10.0.0.2"],"CreationDate":"20231105143000.000000+060"},{"Name":"x","ProcessId":4,"Started":false,"IPAddress":null,"CreationDate":null}]`}
+	out := []psResult{}
+	_, err := QueryWith("Win32_Process", &out, WithRunner(b), WithWhere("ProcessId > 0"), WithNamespace(`root\cimv2`))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if !reflect.DeepEqual(b.names, []string{"wmic", "powershell"}) {
+		t.Fatalf("expected a fallback to powershell, ran %v", b.names)
+	}
+	script := b.args[1][len(b.args[1])-1]
+	for _, s := range []string{"Get-CimInstance -ClassName 'Win32_Process' -Property 'Name','ProcessId','Started','IPAddress','CreationDate'", "-Namespace 'root\\cimv2'", "-Filter 'ProcessId > 0'"} {
+		if !strings.Contains(script, s) {
+			t.Errorf("expected %s in %s", s, script)
+		}
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %v", len(out))
+	}
+	if out[0].Name != "svchost.exe" || out[0].ProcessId != 1068 || !out[0].Started || !reflect.DeepEqual(out[0].IPAddress, []string{"10.0.0.1", "10.0.0.2"}) || out[0].CreatedDate.Hour() != 14 {
+		t.Errorf("unexpected record %+v", out[0])
+	}
+	if out[1].Started || out[1].IPAddress != nil || !out[1].CreatedDate.IsZero() {
+		t.Errorf("unexpected record %+v", out[1])
+	}
+
+}
+
+func TestPowerShellArrayElements(t *testing.T) {
+
+	b := &backendRunner{stdout: `[{"Name":"x","ProcessId":4,"IPAddress":["a,b","say \"hi\"","c"]}]`}
+	out := []psResult{}
+	_, err := QueryWith("Win32_Process", &out, WithRunner(b), WithBackend(BackendPowerShell))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 1 || !reflect.DeepEqual(out[0].IPAddress, []string{"a,b", `say "hi"`, "c"}) {
+		t.Fatalf("expected the element boundaries to be kept, got %+v", out)
+	}
+
+}
+
+func TestPowerShellEmpty(t *testing.T) {
+
+	for _, stdout := range []string{"", "[]"} {
+		out := []psResult{{Name: "stale"}}
+		_, err := QueryWith("Win32_Process", &out, WithRunner(&backendRunner{stdout: stdout}), WithBackend(BackendPowerShell))
+		if err != nil || len(out) != 0 {
+			t.Errorf("expected no records for %q, got %v %v", stdout, out, err)
+		}
+	}
+
+}
+
+func TestWMICBackendNoFallback(t *testing.T) {
+
+	b := &backendRunner{}
+	out := []psResult{}
+	_, err := QueryWith("Win32_Process", &out, WithRunner(b), WithBackend(BackendWMIC))
+	if !errors.Is(err, ErrWmicNotFound) || len(b.names) != 1 {
+		t.Fatalf("expected ErrWmicNotFound without a fallback, got %v running %v", err, b.names)
+	}
+	if !strings.Contains(err.Error(), "deprecated") {
+		t.Fatalf("expected the error to mention wmic is deprecated, got %s", err)
+	}
+
+}
+
+func TestIterWmicNotFound(t *testing.T) {
+
+	b := &backendRunner{stdout: `[{"DisplayName":"Print Spooler","Name":"Spooler","State":"Running"}]`}
+	useRunner(t, b)
+	var s win32Service
+	it, err := QueryIter(context.Background(), "Win32_Service", nil, "", &s)
+	if err != nil {
+		t.Fatalf("expected a fallback to powershell, got %v", err)
+	}
+	defer it.Close()
+	if !it.Next() || it.Scan(&s) != nil || s.Name != "Spooler" || it.Next() || it.Err() != nil {
+		t.Fatalf("unexpected record %+v %v", s, it.Err())
+	}
+	if !reflect.DeepEqual(b.names, []string{"wmic", "powershell"}) {
+		t.Fatalf("expected a fallback to powershell, ran %v", b.names)
+	}
+
+	ok, err := Exists("Win32_Service", "Name='Spooler'")
+	if err != nil || !ok {
+		t.Fatalf("expected Exists to fall back to powershell, got %v %v", ok, err)
+	}
+
+	useRunner(t, &fakeRunner{err: &exec.Error{Name: "wmic", Err: exec.ErrNotFound}})
+	_, err = QueryIter(context.Background(), "Win32_Service", nil, "", &s)
+	if !errors.Is(err, ErrWmicNotFound) {
+		t.Fatalf("expected ErrWmicNotFound without powershell, got %v", err)
+	}
+
+}
+
+func TestQueryAssociators(t *testing.T) {
+
+	type diskDrive struct {
+		DeviceID string
+		Model    string
+	}
+	f := &fakeRunner{stdout: `[{"DeviceID":"\\\\.\\PHYSICALDRIVE0","Model":"Samsung SSD 970"}]`}
+	useRunner(t, f)
+	out := []diskDrive{}
+	_, err := QueryAssociators("Win32_DiskPartition", `DeviceID="Disk #0, Partition #0"`, "Win32_DiskDrive", &out)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 1 || out[0].DeviceID != `\\.\PHYSICALDRIVE0` || out[0].Model != "Samsung SSD 970" {
+		t.Fatalf("unexpected disks %v", out)
+	}
+	script := f.args[0][len(f.args[0])-1]
+	expected := `$r = Get-CimInstance -ClassName 'Win32_DiskPartition' -Filter 'DeviceID="Disk #0, Partition #0"' | Get-CimAssociatedInstance -ResultClassName 'Win32_DiskDrive' | Select-Object -Property 'DeviceID','Model';`
+	if !strings.HasPrefix(script, expected) {
+		t.Fatalf("unexpected script %s", script)
+	}
+
+}
+
+func TestPowerShellAllColumns(t *testing.T) {
+
+	f := &fakeRunner{stdout: `[{"Name":"Spooler","State":"Running","StartMode":"Auto"}]`}
+	useRunner(t, f)
+	out := []rawRecord{}
+	_, err := QueryWith("Win32_Service", &out, WithAllColumns(), WithBackend(BackendPowerShell))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 1 || out[0].fields["StartMode"] != "Auto" || len(out[0].fields) != 3 {
+		t.Fatalf("unexpected records %v", out)
+	}
+	script := f.args[0][len(f.args[0])-1]
+	if !strings.HasPrefix(script, "$r = Get-CimInstance -ClassName 'Win32_Service' | Select-Object -Property * -ExcludeProperty 'CimClass',") {
+		t.Fatalf("expected every property to be selected, got %s", script)
+	}
+
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package wmic
+
+import (
+	"context"
+	"os/exec"
+)
+
+// command returns a command that is killed when the context is cancelled
+func command(ctx context.Context, name string, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
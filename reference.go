@@ -0,0 +1,135 @@
+package wmic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reference is a reference property of an association class, such as the
+// Antecedent and Dependent of Win32_DependentService, which is the object path
+// of the instance it refers to, e.g.
+// \\SERVER1\root\cimv2:Win32_Service.Name="Spooler"
+type Reference struct {
+	// Server and Namespace are empty for a relative path
+	Server    string
+	Namespace string
+	Class     string
+	// Keys are the key properties of the instance, empty for a singleton such
+	// as Win32_WmiSetting=@
+	Keys map[string]string
+}
+
+// UnmarshalText parses an object path. Backslashes and quotes escaped in the
+// key values are unescaped
+func (r *Reference) UnmarshalText(text []byte) error {
+	s := string(text)
+	ref := Reference{Keys: map[string]string{}}
+	if strings.HasPrefix(s, `\\`) {
+		server, rest, ok := strings.Cut(s[2:], `\`)
+		if !ok || server == "" {
+			return fmt.Errorf("Invalid reference %s", text)
+		}
+		ref.Server, s = server, rest
+	}
+	if i := strings.IndexAny(s, ".=:"); i >= 0 && s[i] == ':' {
+		ref.Namespace, s = s[:i], s[i+1:]
+	} else if ref.Server != "" {
+		return fmt.Errorf("Invalid reference %s, it has no namespace", text)
+	}
+
+	i := strings.IndexAny(s, ".=")
+	if i <= 0 || !isIdentifier(s[:i]) {
+		return fmt.Errorf("Invalid reference %s, it has no class", text)
+	}
+	ref.Class = s[:i]
+	if s[i] == '=' {
+		if s[i+1:] != "@" {
+			return fmt.Errorf("Invalid reference %s", text)
+		}
+		*r = ref
+		return nil
+	}
+	if err := parseKeys(s[i+1:], ref.Keys); err != nil {
+		return fmt.Errorf("Invalid reference %s, %s", text, err)
+	}
+	*r = ref
+	return nil
+}
+
+// parseKeys parses the comma separated key properties of an object path, e.g.
+// Name="a",Id=1, into keys
+func parseKeys(s string, keys map[string]string) error {
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || !isIdentifier(name) {
+			return errors.New("the keys are malformed")
+		}
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			closed := false
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				} else if rest[i] == '"' {
+					closed = true
+					break
+				}
+				value.WriteByte(rest[i])
+			}
+			if !closed {
+				return fmt.Errorf("the value of %s isn't closed", name)
+			}
+			rest = rest[i+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(rest[:end])
+			rest = rest[end:]
+		}
+		keys[name] = value.String()
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return fmt.Errorf("the value of %s is followed by %s", name, rest)
+		}
+		s = strings.TrimPrefix(rest, ",")
+	}
+	if len(keys) == 0 {
+		return errors.New("there are no keys")
+	}
+	return nil
+}
+
+// String returns the object path, with the keys sorted by name
+func (r Reference) String() string {
+	var b strings.Builder
+	if r.Server != "" {
+		b.WriteString(`\\` + r.Server + `\`)
+	}
+	if r.Namespace != "" {
+		b.WriteString(r.Namespace + ":")
+	}
+	b.WriteString(r.Class)
+	if len(r.Keys) == 0 {
+		b.WriteString("=@")
+		return b.String()
+	}
+	names := make([]string, 0, len(r.Keys))
+	for name := range r.Keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i == 0 {
+			b.WriteString(".")
+		} else {
+			b.WriteString(",")
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(r.Keys[name])
+		b.WriteString(name + `="` + value + `"`)
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package wmic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dependentService struct {
+	Antecedent Reference
+	Dependent  *Reference
+}
+
+func TestDecodeReference(t *testing.T) {
+
+	data := "\r\r\nAntecedent=\\\\SERVER1\\root\\cimv2:Win32_Service.Name=\"RpcSs\"\r\r\nDependent=\\\\SERVER1\\root\\cimv2:Win32_DiskDrive.DeviceID=\"\\\\\\\\.\\\\PHYSICALDRIVE0\",Index=0\r\r\n\r\r\n" +
+		"Antecedent=Win32_WmiSetting=@\r\r\nDependent=Win32_Service.Name=\"say \\\"hi\\\"\"\r\r\n\r\r\n" +
+		"Antecedent=\\\\SERVER1\\Win32_Service.Name=\"x\"\r\r\nDependent=Win32_Service.Name=\"x\r\r\n\r\r\n"
+	out := []dependentService{}
+	errs, err := decode(strings.NewReader(data), "Win32_DependentService", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 records, got %+v", out)
+	}
+
+	want := Reference{Server: "SERVER1", Namespace: `root\cimv2`, Class: "Win32_Service", Keys: map[string]string{"Name": "RpcSs"}}
+	if !reflect.DeepEqual(out[0].Antecedent, want) {
+		t.Fatalf("got %+v, want %+v", out[0].Antecedent, want)
+	}
+	if d := out[0].Dependent; d == nil || d.Class != "Win32_DiskDrive" || !reflect.DeepEqual(d.Keys, map[string]string{"DeviceID": `\\.\PHYSICALDRIVE0`, "Index": "0"}) {
+		t.Fatalf("unexpected dependent %+v", d)
+	}
+	if got := out[0].Dependent.String(); got != `\\SERVER1\root\cimv2:Win32_DiskDrive.DeviceID="\\\\.\\PHYSICALDRIVE0",Index="0"` {
+		t.Fatalf("unexpected path %s", got)
+	}
+
+	if a := out[1].Antecedent; a.Class != "Win32_WmiSetting" || len(a.Keys) != 0 || a.String() != "Win32_WmiSetting=@" {
+		t.Fatalf("unexpected singleton %+v", a)
+	}
+	if d := out[1].Dependent; d == nil || d.Server != "" || d.Keys["Name"] != `say "hi"` {
+		t.Fatalf("unexpected relative path %+v", d)
+	}
+
+	if len(errs) != 2 || errs[0].Field != "Antecedent" || errs[1].Field != "Dependent" || errs[0].Record != 3 || out[2].Dependent != nil {
+		t.Fatalf("expected record errors for the malformed references, got %+v", errs)
+	}
+
+}
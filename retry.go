@@ -0,0 +1,70 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// retryable returns true if the query should be run again after err
+func (o *QueryOptions) retryable(err error) bool {
+	retryOn := o.RetryOn
+	if retryOn == nil {
+		retryOn = []error{ErrRPCUnavailable}
+	}
+	for _, target := range retryOn {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// runRetry runs the query until it succeeds, fails with an error that isn't
+// retryable, runs out of attempts or the context is done. Each attempt is
+// limited to AttemptTimeout if set, and an attempt that times out is retried.
+// The backoff doubles after each attempt
+func runRetry(ctx context.Context, class string, out interface{}, innerType reflect.Type, o *QueryOptions) ([]RecordError, error) {
+	backoff := o.Backoff
+	for attempt := 1; ; attempt++ {
+		recordErrors, err := runAttempt(ctx, class, out, innerType, o)
+		if err == nil || attempt >= o.Attempts || ctx.Err() != nil {
+			return recordErrors, err
+		}
+		if !o.retryable(err) && !errors.Is(err, errAttemptTimeout) {
+			return recordErrors, err
+		}
+		if o.OnRetry != nil {
+			o.OnRetry(attempt, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return recordErrors, errors.Join(ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// errAttemptTimeout is returned when an attempt takes longer than
+// AttemptTimeout but the query's context isn't done
+var errAttemptTimeout = errors.New("Attempt timed out")
+
+// runAttempt runs the query once, limited to AttemptTimeout if set
+func runAttempt(ctx context.Context, class string, out interface{}, innerType reflect.Type, o *QueryOptions) ([]RecordError, error) {
+	if o.AttemptTimeout == 0 {
+		return runOnce(ctx, class, out, innerType, o)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, o.AttemptTimeout)
+	defer cancel()
+	recordErrors, err := runOnce(attemptCtx, class, out, innerType, o)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() != nil {
+		return recordErrors, fmt.Errorf("%w after %s: %w", errAttemptTimeout, o.AttemptTimeout, err)
+	}
+	return recordErrors, err
+}
@@ -0,0 +1,136 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const rpcUnavailable = "Node - SERVER1\r\nERROR:\r\nCode = 0x800706ba\r\nDescription = The RPC server is unavailable.\r\nFacility = Win32\r\n"
+
+func TestRetry(t *testing.T) {
+
+	s := &sequenceRunner{fakes: []*fakeRunner{
+		{stderr: rpcUnavailable, err: errors.New("exit status 2147944122")},
+		{stderr: rpcUnavailable, err: errors.New("exit status 2147944122")},
+		{stdout: serviceOutput},
+	}}
+	attempts := []int{}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(s), WithNode("SERVER1"),
+		WithRetry(3, time.Millisecond), WithOnRetry(func(attempt int, err error) {
+			attempts = append(attempts, attempt)
+		}))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || len(s.args) != 3 || len(attempts) != 2 || attempts[1] != 2 {
+		t.Fatalf("expected 2 retries, got %d runs %v", len(s.args), attempts)
+	}
+
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+
+	f := &fakeRunner{stderr: "ERROR:\r\nDescription = Invalid class \r\n", err: errors.New("exit status 44135")}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Servic", &out, WithRunner(f), WithRetry(3, time.Millisecond))
+	if !errors.Is(err, ErrInvalidClass) || len(f.args) != 1 {
+		t.Fatalf("expected a single run for an invalid class, got %d runs %v", len(f.args), err)
+	}
+
+	f = &fakeRunner{stderr: rpcUnavailable}
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f), WithRetry(3, time.Millisecond), WithRetryOn(ErrAccessDenied))
+	if !errors.Is(err, ErrRPCUnavailable) || len(f.args) != 1 {
+		t.Fatalf("expected a single run when retrying on access denied, got %d runs %v", len(f.args), err)
+	}
+
+}
+
+func TestRetryDeadline(t *testing.T) {
+
+	f := &fakeRunner{stderr: rpcUnavailable}
+	out := []win32Service{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := query(ctx, "Win32_Service", &out, newOptions([]Option{WithRunner(f), WithRetry(10, 5*time.Millisecond)}))
+	if !errors.Is(err, ErrRPCUnavailable) {
+		t.Fatalf("expected ErrRPCUnavailable, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || len(f.args) >= 10 {
+		t.Fatalf("expected retries to stop at the deadline, got %d runs in %s", len(f.args), elapsed)
+	}
+
+}
+
+func TestRetryCancelled(t *testing.T) {
+
+	f := &fakeRunner{stderr: rpcUnavailable}
+	out := []win32Service{}
+	ctx, cancel := context.WithCancel(context.Background())
+	retried := make(chan struct{}, 10)
+	go func() {
+		<-retried
+		cancel()
+	}()
+	start := time.Now()
+	_, err := QueryWithContext(ctx, "Win32_Service", &out, WithRunner(f), WithRetry(10, time.Second),
+		WithOnRetry(func(attempt int, err error) { retried <- struct{}{} }))
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrRPCUnavailable) {
+		t.Fatalf("expected the cancellation and the last error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the retry to stop when cancelled, took %s", elapsed)
+	}
+	if len(f.args) != 1 {
+		t.Fatalf("expected a single run before the cancel, got %d", len(f.args))
+	}
+
+}
+
+// hangOnceRunner hangs on the first run and then runs the fake
+type hangOnceRunner struct {
+	runs int
+	fake *fakeRunner
+}
+
+func (h *hangOnceRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	h.runs++
+	if h.runs == 1 {
+		return hangRunner{}.Run(ctx, name, args)
+	}
+	return h.fake.Run(ctx, name, args)
+}
+
+func TestAttemptTimeout(t *testing.T) {
+
+	h := &hangOnceRunner{fake: &fakeRunner{stdout: serviceOutput}}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(h), WithRetry(2, time.Millisecond),
+		WithAttemptTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if h.runs != 2 || len(out) != 2 {
+		t.Fatalf("expected the hung attempt to be retried, got %d runs %v", h.runs, out)
+	}
+
+	_, err = QueryWith("Win32_Service", &out, WithRunner(hangRunner{}), WithRetry(2, time.Millisecond),
+		WithAttemptTimeout(10*time.Millisecond))
+	if !errors.Is(err, errAttemptTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the attempt timeout, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = QueryWith("Win32_Service", &out, WithRunner(hangRunner{}), WithRetry(100, time.Millisecond),
+		WithAttemptTimeout(time.Second), WithTimeout(20*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errAttemptTimeout) {
+		t.Fatalf("expected the overall timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the overall timeout to stop the attempt, took %s", elapsed)
+	}
+
+}
@@ -0,0 +1,104 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Rows iterates over the records of a query like database/sql Rows, scanning
+// the columns into variables instead of decoding into a struct
+type Rows struct {
+	it      *Iter
+	cancel  context.CancelFunc
+	columns []string
+	values  map[string]string
+}
+
+// QueryRows starts a query for the columns and returns the rows. Every
+// property is returned if columns is empty, in the order wmic outputs them.
+// The rows must be closed if Next isn't called until it returns false
+func QueryRows(class string, columns []string, where string, opts ...Option) (*Rows, error) {
+	if len(columns) == 0 {
+		columns = []string{AllColumns}
+	}
+	ctx, cancel, err := withTimeout(context.Background(), newOptions(opts).timeout())
+	if err != nil {
+		return nil, err
+	}
+	it, err := QueryIter(ctx, class, columns, where, &rawRecord{}, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rows := &Rows{it: it, cancel: cancel}
+	if columns[0] != AllColumns {
+		rows.columns = columns
+	}
+	return rows, nil
+}
+
+// Next prepares the next row for Scan, returning false when there are no more
+// rows or an error occurred, which is returned by Err
+func (r *Rows) Next() bool {
+	r.values = nil
+	if !r.it.Next() {
+		r.Close()
+		return false
+	}
+	r.values = make(map[string]string, len(r.it.props))
+	all := r.columns == nil
+	for _, p := range r.it.props {
+		r.values[p.name] = p.value
+		if all {
+			r.columns = append(r.columns, p.name)
+		}
+	}
+	return true
+}
+
+// Columns returns the column names. If every property was requested the
+// names are only known after the first call to Next
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Scan copies the columns of the current row into the values pointed at by
+// dest, in column order. dest can point to any type a struct field can be
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.values == nil {
+		return errors.New("Scan called without a successful call to Next")
+	}
+	if len(dest) != len(r.columns) {
+		return fmt.Errorf("Expected %d destination arguments in Scan, not %d", len(r.columns), len(dest))
+	}
+	for i, d := range dest {
+		v := reflect.ValueOf(d)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("Destination %d for %s is not a pointer", i, r.columns[i])
+		}
+		s, ok := r.values[r.columns[i]]
+		if !ok {
+			return fmt.Errorf("Column %s is not in the row", r.columns[i])
+		}
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		err := setValue(r.columns[i], s, v.Elem(), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Err returns the error that stopped Next
+func (r *Rows) Err() error {
+	return r.it.Err()
+}
+
+// Close stops the query, it is safe to call more than once
+func (r *Rows) Close() error {
+	err := r.it.Close()
+	r.cancel()
+	return err
+}
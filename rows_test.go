@@ -0,0 +1,78 @@
+package wmic
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryRows(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\nCreationDate=20231105143000.000000+060\r\r\nName=svchost.exe\r\r\nProcessId=1068\r\r\n\r\r\n\r\r\nCreationDate=\r\r\nName=System\r\r\nProcessId=4\r\r\n\r\r\n"}
+	useRunner(t, f)
+	rows, err := QueryRows("Win32_Process", []string{"ProcessId", "Name", "CreationDate"}, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	defer rows.Close()
+	if strings.Join(rows.Columns(), ",") != "ProcessId,Name,CreationDate" {
+		t.Fatalf("unexpected columns %v", rows.Columns())
+	}
+
+	var names []string
+	var pids []uint32
+	var created time.Time
+	for rows.Next() {
+		var name string
+		var pid uint32
+		err = rows.Scan(&pid, &name, &created)
+		if err != nil {
+			t.Fatalf("scan failed: %s", err)
+		}
+		names = append(names, name)
+		pids = append(pids, pid)
+	}
+	if rows.Err() != nil {
+		t.Fatalf("rows failed: %s", rows.Err())
+	}
+	if strings.Join(names, ",") != "svchost.exe,System" || pids[0] != 1068 || pids[1] != 4 || !created.IsZero() {
+		t.Fatalf("unexpected rows %v %v %v", names, pids, created)
+	}
+	if err = rows.Scan(); err == nil {
+		t.Fatalf("expected an error scanning after the last row")
+	}
+	if !strings.HasSuffix(strings.Join(f.args[0], " "), "GET ProcessId,Name,CreationDate /VALUE") {
+		t.Fatalf("unexpected arguments %v", f.args[0])
+	}
+
+}
+
+func TestQueryRowsAllColumns(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stdout: serviceOutput})
+	rows, err := QueryRows("Win32_Service", nil, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("expected a row, got %v", rows.Err())
+	}
+	if strings.Join(rows.Columns(), ",") != "DisplayName,Name,State" {
+		t.Fatalf("unexpected columns %v", rows.Columns())
+	}
+	var displayName, name, state string
+	if err = rows.Scan(&displayName, &name); err == nil {
+		t.Fatalf("expected an error for too few destinations")
+	}
+	if err = rows.Scan(&displayName, &name, state); err == nil {
+		t.Fatalf("expected an error for a non-pointer destination")
+	}
+	if err = rows.Scan(&displayName, &name, &state); err != nil || name != "Spooler" {
+		t.Fatalf("unexpected row %s %v", name, err)
+	}
+	if !rows.Next() || strings.Join(rows.Columns(), ",") != "DisplayName,Name,State" {
+		t.Fatalf("expected the columns to be unchanged, got %v", rows.Columns())
+	}
+
+}
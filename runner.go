@@ -0,0 +1,192 @@
+package wmic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// Runner runs a command and returns its output. The default ExecRunner runs
+// wmic with os/exec, a fake can be used to test decoding without wmic
+type Runner interface {
+	Run(ctx context.Context, name string, args []string) (stdout, stderr []byte, err error)
+}
+
+// StreamRunner is a Runner that can stream stdout while the command runs,
+// QueryIter and QueryFunc use it if available. wait must be called after
+// stdout has been read and returns the stderr output and the exit error
+type StreamRunner interface {
+	Runner
+	Start(ctx context.Context, name string, args []string) (stdout io.Reader, wait func() (stderr []byte, err error), err error)
+}
+
+// waitDelay is how long to wait for the output pipes to close after the
+// process is killed, in case a child process still holds them open
+const waitDelay = time.Second
+
+// DefaultRunner is used by queries that don't set a Runner
+var DefaultRunner Runner = ExecRunner{}
+
+// ExecRunner runs commands with os/exec, killing the process and any processes
+// it started if the context is cancelled
+type ExecRunner struct{}
+
+// Run runs the command and waits for it to finish
+func (ExecRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	cmd := command(ctx, name, args)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// Start starts the command with a pipe for stdout
+func (ExecRunner) Start(ctx context.Context, name string, args []string) (io.Reader, func() ([]byte, error), error) {
+	cmd := command(ctx, name, args)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	wait := func() ([]byte, error) {
+		err := cmd.Wait()
+		return stderr.Bytes(), err
+	}
+	return stdout, wait, nil
+}
+
+// start runs the command with the runner, streaming stdout if it supports it
+func start(ctx context.Context, runner Runner, name string, args []string) (io.Reader, func() ([]byte, error), error) {
+	if sr, ok := runner.(StreamRunner); ok {
+		return sr.Start(ctx, name, args)
+	}
+	stdout, stderr, err := runner.Run(ctx, name, args)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil, err
+	}
+	wait := func() ([]byte, error) {
+		return stderr, err
+	}
+	return bytes.NewReader(stdout), wait, nil
+}
+
+// Logger logs each command run by a query. Passwords are redacted from the
+// arguments
+type Logger interface {
+	// Start is called before the command is run
+	Start(name string, args []string)
+	// Done is called after the command exits with the exit code, or -1 if it
+	// couldn't be run, and its stderr output
+	Done(name string, args []string, duration time.Duration, exitCode int, stderr []byte, err error)
+}
+
+// logRunner calls the logger around each command
+type logRunner struct {
+	runner Runner
+	logger Logger
+}
+
+func (l logRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	redacted := redactArgs(args)
+	l.logger.Start(name, redacted)
+	start := time.Now()
+	stdout, stderr, err := l.runner.Run(ctx, name, args)
+	l.logger.Done(name, redacted, time.Since(start), logCode(err), stderr, err)
+	return stdout, stderr, err
+}
+
+// Start streams stdout if the runner supports it, the command is logged as done
+// when wait returns
+func (l logRunner) Start(ctx context.Context, name string, args []string) (io.Reader, func() ([]byte, error), error) {
+	redacted := redactArgs(args)
+	l.logger.Start(name, redacted)
+	begin := time.Now()
+	stdout, wait, err := start(ctx, l.runner, name, args)
+	if err != nil {
+		l.logger.Done(name, redacted, time.Since(begin), -1, nil, err)
+		return nil, nil, err
+	}
+	logWait := func() ([]byte, error) {
+		stderr, err := wait()
+		l.logger.Done(name, redacted, time.Since(begin), logCode(err), stderr, err)
+		return stderr, err
+	}
+	return stdout, logWait, nil
+}
+
+// logCode returns the exit code to log for the error from a command, -1 if it
+// couldn't be run
+func logCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return -1
+}
+
+// runner returns the runner from the options or the default, logging each
+// command if a Logger is set
+func (o *QueryOptions) runner() Runner {
+	runner := DefaultRunner
+	if o.Runner != nil {
+		runner = o.Runner
+	}
+	if o.Logger != nil {
+		return logRunner{runner: runner, logger: o.Logger}
+	}
+	return runner
+}
+
+// executable returns the wmic executable to run
+func (o *QueryOptions) executable() string {
+	if o.Executable != "" {
+		return o.Executable
+	}
+	return "wmic"
+}
+
+// The global switches set by NonInteractive and FailFast
+const (
+	interactiveOff = "/INTERACTIVE:OFF"
+	failFastOn     = "/FAILFAST:ON"
+)
+
+// runWmic runs wmic with the arguments. If this version of wmic doesn't
+// support the /INTERACTIVE or /FAILFAST switches it is run again without them
+func runWmic(ctx context.Context, args []string, o *QueryOptions) ([]byte, []byte, error) {
+	stdout, stderr, err := o.runner().Run(ctx, o.executable(), args)
+	if err == nil || !(o.NonInteractive || o.FailFast) || !invalidSwitch(transcode(stderr, o.Encoding)) {
+		return stdout, stderr, err
+	}
+	return o.runner().Run(ctx, o.executable(), withoutSwitches(args))
+}
+
+// withoutSwitches returns the arguments without the switches set by
+// NonInteractive and FailFast
+func withoutSwitches(args []string) []string {
+	supported := []string{}
+	for _, a := range args {
+		if a != interactiveOff && a != failFastOn {
+			supported = append(supported, a)
+		}
+	}
+	return supported
+}
+
+// invalidSwitch returns true if the stderr output is wmic's error for a
+// global switch it doesn't support
+func invalidSwitch(stderr []byte) bool {
+	return bytes.Contains(bytes.ToLower(stderr), []byte("invalid global switch"))
+}
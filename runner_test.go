@@ -0,0 +1,585 @@
+package wmic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunner returns canned output and records the arguments it was run with
+type fakeRunner struct {
+	stdout string
+	stderr string
+	err    error
+	mu     sync.Mutex
+	args   [][]string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.args = append(f.args, args)
+	return []byte(f.stdout), []byte(f.stderr), f.err
+}
+
+// useRunner sets DefaultRunner for the duration of a test
+func useRunner(t *testing.T, r Runner) {
+	old := DefaultRunner
+	DefaultRunner = r
+	t.Cleanup(func() { DefaultRunner = old })
+}
+
+const serviceOutput = "\r\r\n\r\r\nDisplayName=Print Spooler\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\n\r\r\nDisplayName=Windows Time\r\r\nName=W32Time\r\r\nState=Stopped\r\r\n\r\r\n"
+
+func TestQueryWithRunner(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithColumns("DisplayName", "Name", "State"))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || out[1].DisplayName != "Windows Time" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(f.args) != 1 || !strings.Contains(strings.Join(f.args[0], " "), "GET DisplayName,Name,State") {
+		t.Fatalf("unexpected args %v", f.args)
+	}
+
+}
+
+func TestQueryNodesConcatenated(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithNode("SERVER1", "SERVER2"))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	nodes := []string{f.args[0][0], f.args[1][0]}
+	sort.Strings(nodes)
+	if len(out) != 4 || len(f.args) != 2 || nodes[1] != `/NODE:"SERVER2"` {
+		t.Fatalf("expected results from both nodes, got %v records from %v", len(out), f.args)
+	}
+
+}
+
+func TestQueryStderr(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stderr: "No Instance(s) Available.\r\n"})
+	out := []win32Service{{Name: "stale"}}
+	_, err := QueryAll("Win32_Service", &out)
+	if err != nil || len(out) != 0 {
+		t.Fatalf("expected an empty result, got %v %v", out, err)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "ERROR:\r\nDescription = Invalid class.\r\n", err: errors.New("exit status 44135")})
+	_, err = QueryAll("Win32_Service", &out)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+}
+
+func TestQueryOne(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stdout: "Name=Spooler\r\r\nState=Running\r\r\n\r\r\n"})
+	out := win32Service{}
+	err := QueryOne("Win32_Service", "Name='Spooler'", &out)
+	if err != nil || out.State != "Running" {
+		t.Fatalf("unexpected result %+v %v", out, err)
+	}
+
+	useRunner(t, &fakeRunner{stdout: serviceOutput})
+	if err := QueryOne("Win32_Service", "", &out); err != ErrMultipleInstances {
+		t.Fatalf("expected ErrMultipleInstances, got %v", err)
+	}
+
+	useRunner(t, &fakeRunner{stdout: "No Instance(s) Available.\r\n"})
+	if err := QueryOne("Win32_Service", "Name='None'", &out); err != ErrNoInstances {
+		t.Fatalf("expected ErrNoInstances, got %v", err)
+	}
+
+}
+
+func TestQueryInstance(t *testing.T) {
+
+	f := &fakeRunner{stdout: "Name=Spooler\r\r\nState=Running\r\r\n\r\r\n"}
+	useRunner(t, f)
+	out := win32Service{}
+	err := QueryInstance(`Win32_Service.Name="Spooler"`, &out)
+	if err != nil || out.State != "Running" {
+		t.Fatalf("unexpected result %+v %v", out, err)
+	}
+	if got := strings.Join(f.args[0], " "); !strings.HasPrefix(got, `PATH Win32_Service.Name="Spooler" GET `) || strings.Contains(got, "WHERE") {
+		t.Fatalf("expected the object path to be queried, got %s", got)
+	}
+
+	useRunner(t, &fakeRunner{stderr: "ERROR:\r\nDescription = Not found \r\n", err: errors.New("exit status 2147749890")})
+	err = QueryInstance(`Win32_Service.Name="None"`, &out)
+	if !errors.Is(err, ErrInstanceNotFound) {
+		t.Fatalf("expected ErrInstanceNotFound, got %v", err)
+	}
+	useRunner(t, &fakeRunner{stdout: "No Instance(s) Available.\r\n"})
+	err = QueryInstance(`Win32_Service.Name="None"`, &out)
+	if !errors.Is(err, ErrInstanceNotFound) {
+		t.Fatalf("expected ErrInstanceNotFound, got %v", err)
+	}
+
+	b := &backendRunner{stdout: `[{"Name":"Spooler","State":"Running"}]`}
+	useRunner(t, b)
+	err = QueryInstance(`Win32_Service.Name="Spooler",State="a,b"`, &out)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	script := b.args[1][len(b.args[1])-1]
+	if !strings.Contains(script, `-ClassName 'Win32_Service'`) || !strings.Contains(script, `-Filter 'Name="Spooler" AND State="a,b"'`) {
+		t.Fatalf("expected the path keys as a filter, got %s", script)
+	}
+
+}
+
+func TestQueryCount(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\nName=Spooler\r\r\n\r\r\n\r\r\nName=W32Time\r\r\n\r\r\n"}
+	useRunner(t, f)
+	n, err := QueryCount("Win32_Service", "State='Running'")
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2, got %v %v", n, err)
+	}
+	if !strings.Contains(strings.Join(f.args[0], " "), "GET Name ") {
+		t.Fatalf("expected only the key property to be queried, got %v", f.args[0])
+	}
+
+	f.stdout = "__RELPATH=Win32_Environment.Name=\"Path\",UserName=\"<SYSTEM>\"\r\r\n\r\r\n"
+	n, err = QueryCount("Win32_Environment", "")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1, got %v %v", n, err)
+	}
+	if !strings.Contains(strings.Join(f.args[1], " "), "GET __RELPATH ") {
+		t.Fatalf("expected only the relative path to be queried, got %v", f.args[1])
+	}
+
+}
+
+// openRunner writes a record and then keeps stdout open until it is killed,
+// like wmic still enumerating a large class
+type openRunner struct {
+	record string
+	killed bool
+}
+
+func (o *openRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	return nil, nil, errors.New("Run called on a StreamRunner")
+}
+
+func (o *openRunner) Start(ctx context.Context, name string, args []string) (io.Reader, func() ([]byte, error), error) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(o.record))
+		<-ctx.Done()
+		w.CloseWithError(ctx.Err())
+	}()
+	wait := func() ([]byte, error) {
+		<-ctx.Done()
+		o.killed = true
+		return nil, ctx.Err()
+	}
+	return r, wait, nil
+}
+
+func TestExists(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\nHandle=4\r\r\n\r\r\n\r\r\nHandle=1068\r\r\n\r\r\n"}
+	useRunner(t, f)
+	ok, err := Exists("Win32_Process", "Name='svchost.exe'")
+	if err != nil || !ok {
+		t.Fatalf("expected an instance to exist, got %v %v", ok, err)
+	}
+	if !strings.Contains(strings.Join(f.args[0], " "), "GET Handle ") {
+		t.Fatalf("expected only the key property to be queried, got %v", f.args[0])
+	}
+
+	f.stdout = "No Instance(s) Available.\r\n"
+	ok, err = Exists("Win32_Process", "Name='none.exe'")
+	if err != nil || ok {
+		t.Fatalf("expected no instance, got %v %v", ok, err)
+	}
+
+	r := &openRunner{record: "\r\r\nHandle=4\r\r\n\r\r\n"}
+	useRunner(t, r)
+	start := time.Now()
+	ok, err = Exists("Win32_Process", "")
+	if err != nil || !ok {
+		t.Fatalf("expected an instance to exist, got %v %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || !r.killed {
+		t.Fatalf("expected wmic to be stopped after the first instance, took %s", elapsed)
+	}
+
+	useRunner(t, hangRunner{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ok, err = ExistsContext(ctx, "Win32_Process", "Name='hung.exe'")
+	if !errors.Is(err, context.DeadlineExceeded) || ok {
+		t.Fatalf("expected the context deadline, got %v %v", ok, err)
+	}
+
+}
+
+func TestIterOptions(t *testing.T) {
+
+	useRunner(t, &fakeRunner{err: errors.New("expected the WithRunner runner")})
+	f := &fakeRunner{stdout: serviceOutput}
+	opts := []Option{WithRunner(f), WithNode("server1")}
+
+	ok, err := Exists("Win32_Service", "Name='Spooler'", opts...)
+	if err != nil || !ok {
+		t.Fatalf("expected an instance to exist, got %v %v", ok, err)
+	}
+	names, err := ListProperties("Win32_Service", opts...)
+	if err != nil || strings.Join(names, ",") != "DisplayName,Name,State" {
+		t.Fatalf("unexpected properties %v %v", names, err)
+	}
+	if _, err := ValidateStruct("Win32_Service", win32Service{}, opts...); err != nil {
+		t.Fatalf("validate failed: %s", err)
+	}
+	var s win32Service
+	if err := QueryFunc("Win32_Service", nil, "", &s, func() error { return nil }, opts...); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	rows, err := QueryRows("Win32_Service", []string{"Name"}, "", opts...)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	rows.Close()
+	var buf bytes.Buffer
+	if err := QueryCSV(&buf, "Win32_Service", []string{"Name"}, "", opts...); err != nil || buf.String() != "Name\r\nSpooler\r\nW32Time\r\n" {
+		t.Fatalf("unexpected CSV %q %v", buf.String(), err)
+	}
+
+	if len(f.args) != 6 {
+		t.Fatalf("expected each query to use the runner, ran %q", f.args)
+	}
+	for _, args := range f.args {
+		if args[0] != `/NODE:"server1"` {
+			t.Fatalf("expected the node to be queried, got %q", args)
+		}
+	}
+
+	if _, err := Exists("Win32_Service", "", WithTimeout(-time.Second)); err == nil {
+		t.Fatal("expected an error for a negative timeout")
+	}
+
+}
+
+func TestQueryMap(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	useRunner(t, f)
+	out, err := QueryMap("Win32_Service", nil, "")
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || out[0]["Name"] != "Spooler" || out[1]["DisplayName"] != "Windows Time" {
+		t.Fatalf("unexpected instances %v", out)
+	}
+	if !strings.HasSuffix(strings.Join(f.args[0], " "), "PATH Win32_Service GET /VALUE") {
+		t.Fatalf("expected all the properties to be queried, got %v", f.args[0])
+	}
+
+	_, err = QueryMap("Win32_Service", []string{"Name", "State"}, "")
+	if err != nil || !strings.Contains(strings.Join(f.args[1], " "), "GET Name,State ") {
+		t.Fatalf("expected the columns to be queried, got %v %v", f.args[1], err)
+	}
+
+}
+
+func TestListProperties(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	useRunner(t, f)
+	names, err := ListProperties("Win32_Service")
+	if err != nil {
+		t.Fatalf("list failed: %s", err)
+	}
+	if strings.Join(names, ",") != "DisplayName,Name,State" {
+		t.Fatalf("unexpected properties %v", names)
+	}
+	if !strings.HasSuffix(strings.Join(f.args[0], " "), "PATH Win32_Service GET /VALUE") {
+		t.Fatalf("expected all the properties to be queried, got %v", f.args[0])
+	}
+
+	useRunner(t, &fakeRunner{stderr: "No Instance(s) Available.\r\n"})
+	_, err = ListProperties("Win32_TapeDrive")
+	if err != ErrNoInstances {
+		t.Fatalf("expected ErrNoInstances, got %v", err)
+	}
+
+}
+
+func TestQueryIter(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stdout: serviceOutput})
+	var s win32Service
+	it, err := QueryIter(context.Background(), "Win32_Service", nil, "", &s)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	defer it.Close()
+	names := []string{}
+	for it.Next() {
+		if err := it.Scan(&s); err != nil {
+			t.Fatalf("scan failed: %s", err)
+		}
+		names = append(names, s.Name)
+	}
+	if it.Err() != nil || strings.Join(names, ",") != "Spooler,W32Time" {
+		t.Fatalf("unexpected records %v %v", names, it.Err())
+	}
+
+}
+
+func TestQueryIterOptions(t *testing.T) {
+
+	type process struct {
+		Name      string
+		ProcessID int
+	}
+	f := &fakeRunner{stdout: "\r\r\nName=svchost.exe\r\r\nProcessId=1044\r\r\n\r\r\n"}
+	l := &testLogger{}
+	var p process
+	it, err := QueryIter(context.Background(), "Win32_Process", nil, "", &p, WithRunner(f), WithIgnoreCase(), WithLogger(l), WithExecutable(`C:\wmic.exe`))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	defer it.Close()
+	if !it.Next() {
+		t.Fatalf("expected a record, got %v", it.Err())
+	}
+	if err := it.Scan(&p); err != nil || p.ProcessID != 1044 {
+		t.Fatalf("expected the field to match ignoring case, got %+v %v", p, err)
+	}
+	if it.Next() || it.Err() != nil {
+		t.Fatalf("expected one record, got %v", it.Err())
+	}
+	if len(f.args) != 1 || len(l.started) != 1 || !strings.HasPrefix(l.started[0], `C:\wmic.exe PATH Win32_Process`) {
+		t.Fatalf("expected the runner and logger to be used, ran %q logged %q", f.args, l.started)
+	}
+
+	r := &oldWmicRunner{}
+	var s win32Service
+	it, err = QueryIter(context.Background(), "Win32_Service", nil, "", &s, WithRunner(r), WithFailFast())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 2 || len(r.args) != 2 {
+		t.Fatalf("expected wmic to run again without the switches, got %v records %v after %q", count, it.Err(), r.args)
+	}
+
+}
+
+func TestQueryFuncStops(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stdout: serviceOutput})
+	var s win32Service
+	stop := errors.New("stop")
+	calls := 0
+	err := QueryFunc("Win32_Service", nil, "", &s, func() error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("expected the callback error after one call, got %v after %v", err, calls)
+	}
+
+}
+
+func TestQueryFuncRecords(t *testing.T) {
+
+	f := &fakeRunner{stdout: "\r\r\nDisplayName=Print Spooler\r\r\nName=Spooler\r\r\n\r\r\n\r\r\nName=W32Time\r\r\nState=Stopped\r\r\n\r\r\n"}
+	var s win32Service
+	seen := []win32Service{}
+	err := QueryFunc("Win32_Service", nil, "", &s, func() error {
+		seen = append(seen, s)
+		return nil
+	}, WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(seen) != 2 || seen[0].DisplayName != "Print Spooler" || seen[1].Name != "W32Time" || seen[1].DisplayName != "" {
+		t.Fatalf("expected each record decoded without the previous values, got %+v", seen)
+	}
+
+	for _, out := range []interface{}{nil, s, &[]win32Service{}} {
+		if err := QueryFunc("Win32_Service", nil, "", out, func() error { return nil }, WithRunner(f)); err == nil {
+			t.Errorf("expected an error for out %T", out)
+		}
+	}
+	if len(f.args) != 1 {
+		t.Fatalf("expected nothing to run for an invalid out, ran %q", f.args)
+	}
+
+}
+
+// sequenceRunner returns each fake's output in turn
+type sequenceRunner struct {
+	fakes []*fakeRunner
+	args  [][]string
+}
+
+func (s *sequenceRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	s.args = append(s.args, args)
+	f := s.fakes[0]
+	if len(s.fakes) > 1 {
+		s.fakes = s.fakes[1:]
+	}
+	return f.Run(ctx, name, args)
+}
+
+type versionedService struct {
+	Name             string
+	State            string
+	DelayedAutoStart bool
+}
+
+func TestIgnoreMissing(t *testing.T) {
+
+	s := &sequenceRunner{fakes: []*fakeRunner{
+		{stderr: "Node - PC\r\nERROR:\r\nDescription = Invalid query\r\n", err: errors.New("exit status 44125")},
+		{stdout: "AcceptPause=FALSE\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\n"},
+	}}
+	out := []versionedService{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(s), WithIgnoreMissing())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 1 || out[0].Name != "Spooler" || out[0].DelayedAutoStart {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(s.args) != 2 || !strings.Contains(strings.Join(s.args[1], " "), "GET /") {
+		t.Fatalf("expected a retry for all properties, got %v", s.args)
+	}
+
+}
+
+func TestExecRunnerCancel(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The background sleep keeps stdout open after sh is killed
+	start := time.Now()
+	_, _, err := ExecRunner{}.Run(ctx, "sh", []string{"-c", "sleep 30 & sleep 30"})
+	if err == nil {
+		t.Fatalf("expected an error for a cancelled command")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the cancelled command to release promptly, took %s", elapsed)
+	}
+
+}
+
+// testLogger records the commands logged
+type testLogger struct {
+	started []string
+	done    []string
+}
+
+func (l *testLogger) Start(name string, args []string) {
+	l.started = append(l.started, name+" "+strings.Join(args, " "))
+}
+
+func (l *testLogger) Done(name string, args []string, duration time.Duration, exitCode int, stderr []byte, err error) {
+	l.done = append(l.done, fmt.Sprintf("%s %d %s", name, exitCode, strings.TrimSpace(string(stderr))))
+}
+
+func TestLogger(t *testing.T) {
+
+	l := &testLogger{}
+	f := &fakeRunner{stderr: "ERROR:\r\nDescription = Invalid class \r\n", err: exitError(44135)}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Servic", &out, WithRunner(f), WithLogger(l), WithNode("SERVER1"), WithCredentials("admin", "secret"))
+	if err == nil {
+		t.Fatalf("expected an error for an invalid class")
+	}
+	if len(l.started) != 1 || !strings.HasPrefix(l.started[0], `wmic /NODE:"SERVER1" /USER:"admin" /PASSWORD:"********" PATH Win32_Servic`) {
+		t.Fatalf("unexpected start %v", l.started)
+	}
+	if strings.Contains(l.started[0], "secret") {
+		t.Fatalf("expected the password to be redacted in %s", l.started[0])
+	}
+	if len(l.done) != 1 || l.done[0] != "wmic 44135 ERROR:\r\nDescription = Invalid class" {
+		t.Fatalf("unexpected done %q", l.done)
+	}
+
+}
+
+// oldWmicRunner fails like a version of wmic without /FAILFAST
+type oldWmicRunner struct {
+	fakeRunner
+}
+
+func (r *oldWmicRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	r.fakeRunner.Run(ctx, name, args)
+	for _, a := range args {
+		if a == "/FAILFAST:ON" {
+			return nil, []byte("Invalid Global Switch.\r\n"), errors.New("exit status 44135")
+		}
+	}
+	return []byte(serviceOutput), nil, nil
+}
+
+func TestGlobalSwitches(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithNode("server1"), WithNonInteractive(), WithFailFast())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if got := strings.Join(f.args[0], " "); !strings.HasPrefix(got, `/NODE:"server1" /INTERACTIVE:OFF /FAILFAST:ON PATH Win32_Service GET`) {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+
+	r := &oldWmicRunner{}
+	_, err = QueryWith("Win32_Service", &out, WithRunner(r), WithNonInteractive(), WithFailFast())
+	if err != nil || len(out) != 2 {
+		t.Fatalf("expected the query to run without the switches, got %v", err)
+	}
+	if len(r.args) != 2 || strings.Contains(strings.Join(r.args[1], " "), "/INTERACTIVE") {
+		t.Fatalf("expected a second run without the switches, ran %q", r.args)
+	}
+
+	// wmic CALL and SET are run again too
+	r = &oldWmicRunner{}
+	client := NewClient(WithRunner(r), WithFailFast())
+	err = client.SetProperty("Win32_Service", "Name='Spooler'", map[string]string{"StartMode": "Auto"})
+	if err == nil || !strings.Contains(err.Error(), "Property update failed") || len(r.args) != 2 {
+		t.Fatalf("expected the second run's output, got %v after %q", err, r.args)
+	}
+
+	// Without the options the invalid switch error is returned
+	f = &fakeRunner{stderr: "Invalid Global Switch.\r\n", err: errors.New("exit status 44135")}
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f))
+	if err == nil || len(f.args) != 1 {
+		t.Fatalf("expected the error after one run, got %v after %q", err, f.args)
+	}
+
+}
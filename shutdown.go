@@ -0,0 +1,72 @@
+package wmic
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle is cancelled by Shutdown, every query runs under its context
+var lifecycle struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func init() {
+	lifecycle.ctx, lifecycle.cancel = context.WithCancel(context.Background())
+}
+
+// Shutdown cancels every running query, killing its wmic or PowerShell
+// process, for a graceful shutdown of a long running service. Queries that are
+// cancelled, and any query started after Shutdown, return context.Canceled
+// until Restart is called. Shutdown doesn't wait for the queries to return, it
+// is safe to call more than once. Use Client.Close to only stop the queries of
+// one client
+func Shutdown() {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	lifecycle.cancel()
+}
+
+// Restart allows queries to run again after Shutdown, e.g. between tests or
+// when a service is started again in the same process. Queries cancelled by
+// Shutdown stay cancelled
+func Restart() {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	if lifecycle.ctx.Err() != nil {
+		lifecycle.ctx, lifecycle.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// withShutdown returns a context that is done when ctx is done, Shutdown is
+// called or the client running the query is closed. The cancel function must
+// be called once the query has finished
+func withShutdown(ctx context.Context, o *QueryOptions) (context.Context, context.CancelFunc) {
+	lifecycle.mu.Lock()
+	parents := []context.Context{lifecycle.ctx}
+	lifecycle.mu.Unlock()
+	if o.client != nil {
+		parents = append(parents, o.client)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	for _, parent := range parents {
+		if parent.Err() != nil {
+			// AfterFunc cancels in its own goroutine, so cancel now for a
+			// query started after Shutdown to fail before it runs
+			cancel()
+			return ctx, cancel
+		}
+	}
+	stops := make([]func() bool, len(parents))
+	for i, parent := range parents {
+		stops[i] = context.AfterFunc(parent, cancel)
+	}
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+	}
+}
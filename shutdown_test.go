@@ -0,0 +1,147 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+
+	t.Cleanup(Restart)
+
+	errs := make(chan error, 3)
+	go func() {
+		out := []win32Service{}
+		_, err := QueryWith("Win32_Service", &out, WithRunner(hangRunner{}))
+		errs <- err
+	}()
+	go func() {
+		_, _, err := callMethod(context.Background(), "Win32_Service", "StopService", nil, &QueryOptions{Runner: hangRunner{}})
+		errs <- err
+	}()
+	useRunner(t, &openRunner{record: "\r\r\nName=Spooler\r\r\n\r\r\n"})
+	it, err := QueryIter(context.Background(), "Win32_Service", nil, "", &win32Service{})
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	go func() {
+		for it.Next() {
+		}
+		errs <- it.Err()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	Shutdown()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected the query to be cancelled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected every running query to stop on Shutdown")
+		}
+	}
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f))
+	if !errors.Is(err, context.Canceled) || len(out) != 0 {
+		t.Fatalf("expected a query after Shutdown to fail, got %v %v", out, err)
+	}
+	_, _, err = callMethod(context.Background(), "Win32_Service", "StopService", nil, &QueryOptions{Runner: f})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a method call after Shutdown to fail, got %v", err)
+	}
+	err = setProperty(context.Background(), "Win32_Service", map[string]string{"StartMode": "Manual"}, &QueryOptions{Runner: f})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected setting a property after Shutdown to fail, got %v", err)
+	}
+	useRunner(t, f)
+	_, err = QueryIter(context.Background(), "Win32_Service", nil, "", &win32Service{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected an iterator after Shutdown to fail, got %v", err)
+	}
+	if len(f.args) != 0 {
+		t.Fatalf("expected nothing to run after Shutdown, got %v", f.args)
+	}
+	Shutdown()
+
+}
+
+func TestRestart(t *testing.T) {
+
+	t.Cleanup(Restart)
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	Shutdown()
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(f)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a query after Shutdown to fail, got %v", err)
+	}
+	Restart()
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(f)); err != nil || len(out) != 2 {
+		t.Fatalf("expected queries to run after Restart, got %v %v", out, err)
+	}
+	Restart()
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(f)); err != nil {
+		t.Fatalf("expected Restart without Shutdown to do nothing, got %v", err)
+	}
+
+}
+
+func TestClientClose(t *testing.T) {
+
+	c := NewClient(WithRunner(hangRunner{}))
+	other := NewClient(WithRunner(&fakeRunner{stdout: serviceOutput}))
+	errs := make(chan error, 1)
+	go func() {
+		out := []win32Service{}
+		_, err := c.QueryAll("Win32_Service", &out)
+		errs <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the query to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the running query to stop on Close")
+	}
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	if _, err := c.QueryWith("Win32_Service", &out, WithRunner(f)); !errors.Is(err, context.Canceled) || len(f.args) != 0 {
+		t.Fatalf("expected a query after Close to fail, got %v after %v", err, f.args)
+	}
+	if _, err := c.Exists("Win32_Service", "", WithRunner(f)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Exists after Close to fail, got %v", err)
+	}
+	if _, _, err := c.CallMethod("Win32_Service", "", "StopService", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a method call after Close to fail, got %v", err)
+	}
+	snapshots, err := WatchClient[win32Service](context.Background(), c, "Win32_Service", nil, "", time.Millisecond, WithRunner(f))
+	if err != nil {
+		t.Fatalf("watch failed: %s", err)
+	}
+	select {
+	case _, ok := <-snapshots:
+		if ok {
+			t.Fatalf("expected no snapshots after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the watch channel to be closed")
+	}
+	if _, err := other.QueryAll("Win32_Service", &out); err != nil || len(out) != 2 {
+		t.Fatalf("expected other clients to keep working, got %v %v", out, err)
+	}
+	if _, err := QueryWith("Win32_Service", &out, WithRunner(f)); err != nil {
+		t.Fatalf("expected the package functions to keep working, got %v", err)
+	}
+	c.Close()
+
+}
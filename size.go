@@ -0,0 +1,101 @@
+package wmic
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the power of the KB size for each unit. Binary units such as
+// MiB are always powers of 1024
+var byteUnits = map[string]struct {
+	power  int
+	binary bool
+}{
+	"":      {0, false},
+	"B":     {0, false},
+	"BYTES": {0, false},
+	"K":     {1, false},
+	"KB":    {1, false},
+	"KIB":   {1, true},
+	"M":     {2, false},
+	"MB":    {2, false},
+	"MIB":   {2, true},
+	"G":     {3, false},
+	"GB":    {3, false},
+	"GIB":   {3, true},
+	"T":     {4, false},
+	"TB":    {4, false},
+	"TIB":   {4, true},
+	"P":     {5, false},
+	"PB":    {5, false},
+	"PIB":   {5, true},
+	"E":     {6, false},
+	"EB":    {6, false},
+	"EIB":   {6, true},
+}
+
+// byteBase returns the size of a KB for a number field tagged bytes, e.g.
+// wmi:"Size,bytes". A KB is 1024 bytes as Windows shows sizes, or 1000 with
+// bytes=decimal
+func (o tagOptions) byteBase() (uint64, bool) {
+	if o.has("bytes") {
+		return 1024, true
+	}
+	switch v, _ := o.value("bytes"); v {
+	case "binary":
+		return 1024, true
+	case "decimal":
+		return 1000, true
+	}
+	return 0, false
+}
+
+// ParseByteSize converts a size such as 512MB, 1.5 GB or 4 GiB to the number
+// of bytes, a KB being kb bytes, 1024 as Windows shows sizes or 1000. Binary
+// units such as MiB are always powers of 1024 and a plain number is already
+// bytes. It is used for fields tagged bytes and by units.Bytes
+func ParseByteSize(s string, kb uint64) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	number, unit := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	u, ok := byteUnits[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("Unable to parse size %s", s)
+	}
+	if u.binary {
+		kb = 1024
+	}
+	multiple := uint64(1)
+	for p := 0; p < u.power; p++ {
+		multiple *= kb
+	}
+
+	if !strings.Contains(number, ".") {
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to parse size %s", s)
+		}
+		hi, lo := bits.Mul64(n, multiple)
+		if hi != 0 {
+			return 0, fmt.Errorf("Size %s is too large", s)
+		}
+		return lo, nil
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse size %s", s)
+	}
+	size := math.Round(n * float64(multiple))
+	if size >= math.MaxUint64 {
+		return 0, fmt.Errorf("Size %s is too large", s)
+	}
+	return uint64(size), nil
+}
@@ -0,0 +1,69 @@
+package wmic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+
+	tests := []struct {
+		s    string
+		base uint64
+		want uint64
+	}{
+		{"2048", 1024, 2048},
+		{"512MB", 1024, 536870912},
+		{"512 mb", 1000, 512000000},
+		{"1.5 GB", 1024, 1610612736},
+		{"1.5 GB", 1000, 1500000000},
+		{"4 GiB", 1000, 4294967296},
+		{"2TB", 1000, 2000000000000},
+		{"3 PB", 1024, 3 << 50},
+		{"2 pib", 1000, 2 << 50},
+		{"15 EB", 1024, 15 << 60},
+		{"100 bytes", 1024, 100},
+		{" 1 k ", 1024, 1024},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.s, tt.base)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseByteSize(%q, %d) = %d, %v, expected %d", tt.s, tt.base, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "12 XB", "GB", "1.2.3 MB", "99999999999 TB", "16 EB"} {
+		if _, err := ParseByteSize(s, 1024); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+
+}
+
+type aliasDisk struct {
+	Name      string
+	Size      uint64  `wmi:"Size,bytes"`
+	FreeSpace *int64  `wmi:"FreeSpace,bytes=decimal"`
+	Used      float64 `wmi:"Used,bytes=binary"`
+}
+
+func TestDecodeByteSize(t *testing.T) {
+
+	data := "\r\r\nFreeSpace=1,5 GB\r\r\nName=C:\r\r\nSize=512MB\r\r\nUsed=0,5 KB\r\r\n\r\r\n" +
+		"\r\r\nFreeSpace=\r\r\nName=D:\r\r\nSize=lots\r\r\nUsed=1 KB\r\r\n\r\r\n"
+	out := []aliasDisk{}
+	recordErrors, err := decode(strings.NewReader(data), "LOGICALDISK", &out, &QueryOptions{DecimalSeparator: ","})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Size != 512<<20 || out[0].FreeSpace == nil || *out[0].FreeSpace != 1500000000 || out[0].Used != 512 {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if out[1].Size != 0 || out[1].FreeSpace != nil || out[1].Used != 1024 {
+		t.Fatalf("unexpected record %+v", out[1])
+	}
+	if len(recordErrors) != 1 || recordErrors[0].Field != "Size" {
+		t.Fatalf("expected an error for the size that didn't parse, got %v", recordErrors)
+	}
+
+}
@@ -0,0 +1,83 @@
+// Package units has field types for sizes and percentages in WMI properties,
+// which parse from the raw wmic values and format for display, e.g.
+//
+//	type disk struct {
+//		DeviceID  string
+//		Size      units.Bytes
+//		FreeSpace units.Bytes
+//	}
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cubewise-plim/wmic"
+)
+
+// byteUnits are the units String formats sizes with, each 1024 times the one
+// before as Windows shows sizes
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+
+// Bytes is a size in bytes, such as Win32_LogicalDisk.Size
+type Bytes uint64
+
+// UnmarshalText parses a number of bytes, or a size with a unit such as 512MB,
+// 1.5 GB or 4 GiB where a KB is 1024 bytes, the same as a field tagged bytes
+func (b *Bytes) UnmarshalText(text []byte) error {
+	size, err := wmic.ParseByteSize(string(text), 1024)
+	if err != nil {
+		return err
+	}
+	*b = Bytes(size)
+	return nil
+}
+
+// String returns the size in the largest unit it is at least one of, with
+// up to one decimal place, e.g. 1.5 GB
+func (b Bytes) String() string {
+	size, power := float64(b), 0
+	for size >= 1024 && power < len(byteUnits)-1 {
+		size /= 1024
+		power++
+	}
+	if power == 0 {
+		return strconv.FormatUint(uint64(b), 10) + " B"
+	}
+	return strconv.FormatFloat(math.Round(size*10)/10, 'f', -1, 64) + " " + byteUnits[power]
+}
+
+// PercentOf returns the size as a percentage of total, e.g. the used space of
+// a disk, or 0 if total is 0
+func (b Bytes) PercentOf(total Bytes) Percent {
+	if total == 0 {
+		return 0
+	}
+	return Percent(float64(b) / float64(total) * 100)
+}
+
+// Percent is a percentage, such as Win32_Processor.LoadPercentage
+type Percent float64
+
+// UnmarshalText parses a percentage with or without a % sign, e.g. 45 or 12.5%
+func (p *Percent) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(string(text)), "%"))
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("Invalid percentage %s", text)
+	}
+	*p = Percent(f)
+	return nil
+}
+
+// String returns the percentage with up to one decimal place, e.g. 12.5%
+func (p Percent) String() string {
+	return strconv.FormatFloat(math.Round(float64(p)*10)/10, 'f', -1, 64) + "%"
+}
+
+// Fraction returns the percentage as a fraction of one, e.g. 0.125 for 12.5%
+func (p Percent) Fraction() float64 {
+	return float64(p) / 100
+}
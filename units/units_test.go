@@ -0,0 +1,96 @@
+package units
+
+import (
+	"testing"
+
+	"github.com/cubewise-plim/wmic"
+	"github.com/cubewise-plim/wmic/wmictest"
+)
+
+func TestBytes(t *testing.T) {
+
+	tests := []struct {
+		text string
+		want Bytes
+		s    string
+	}{
+		{"0", 0, "0 B"},
+		{"1023", 1023, "1023 B"},
+		{"1536", 1536, "1.5 KB"},
+		{"512MB", 512 << 20, "512 MB"},
+		{"1.5 GB", 3 << 29, "1.5 GB"},
+		{"2 t", 2 << 40, "2 TB"},
+		{"4 GiB", 4 << 30, "4 GB"},
+		{"3 PB", 3 << 50, "3 PB"},
+		{"18446744073709551615", 1<<64 - 1, "16 EB"},
+	}
+	for _, test := range tests {
+		var b Bytes
+		if err := b.UnmarshalText([]byte(test.text)); err != nil || b != test.want {
+			t.Errorf("%s: got %d %v, want %d", test.text, b, err, test.want)
+		}
+		if got := test.want.String(); got != test.s {
+			t.Errorf("%d: got %s, want %s", test.want, got, test.s)
+		}
+	}
+	for _, text := range []string{"", "GB", "12 XB", "1.2.3", "20000000 TB"} {
+		var b Bytes
+		if err := b.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("expected an error for %q, got %d", text, b)
+		}
+	}
+	if p := Bytes(25).PercentOf(200); p != 12.5 {
+		t.Errorf("expected 12.5, got %v", p)
+	}
+	if p := Bytes(25).PercentOf(0); p != 0 {
+		t.Errorf("expected 0, got %v", p)
+	}
+
+}
+
+func TestPercent(t *testing.T) {
+
+	for text, want := range map[string]Percent{"45": 45, "12.5%": 12.5, " 100 % ": 100} {
+		var p Percent
+		if err := p.UnmarshalText([]byte(text)); err != nil || p != want {
+			t.Errorf("%q: got %v %v, want %v", text, p, err, want)
+		}
+	}
+	for _, text := range []string{"", "%", "high", "NaN"} {
+		var p Percent
+		if err := p.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("expected an error for %q, got %v", text, p)
+		}
+	}
+	if s := Percent(33.333).String(); s != "33.3%" {
+		t.Errorf("expected 33.3%%, got %s", s)
+	}
+	if f := Percent(12.5).Fraction(); f != 0.125 {
+		t.Errorf("expected 0.125, got %v", f)
+	}
+
+}
+
+type logicalDisk struct {
+	DeviceID  string
+	Size      Bytes
+	FreeSpace *Bytes
+}
+
+func TestDecodeUnits(t *testing.T) {
+
+	f := wmictest.NewFakeRunner()
+	f.SetRecords("Win32_LogicalDisk", map[string]string{"DeviceID": "C:", "Size": "107374182400", "FreeSpace": "26843545600"}, map[string]string{"DeviceID": "D:", "Size": "", "FreeSpace": "x"})
+	out := []logicalDisk{}
+	errs, err := wmic.QueryWith("Win32_LogicalDisk", &out, wmic.WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Size.String() != "100 GB" || out[0].FreeSpace == nil || out[0].FreeSpace.PercentOf(out[0].Size).String() != "25%" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if out[1].Size != 0 || out[1].FreeSpace != nil || len(errs) != 1 || errs[0].Field != "FreeSpace" {
+		t.Fatalf("expected a record error for the invalid size, got %+v %+v", out[1], errs)
+	}
+
+}
@@ -0,0 +1,61 @@
+package wmic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ValidateStruct checks the struct fields of out against the properties of
+// the class and returns a message for each field that doesn't match a
+// property. Property names are case-sensitive, so a field that only differs
+// in case is reported with the correct name. out can be a struct, a pointer
+// to a struct or a slice of either
+func ValidateStruct(class string, out interface{}, opts ...Option) ([]string, error) {
+	t, err := structType(out)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := ListProperties(class, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return mismatchedFields(t, properties), nil
+}
+
+// structType returns the struct type of out, which can be a struct, a
+// pointer to a struct or a slice of either
+func structType(out interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(out)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("You must provide a struct to the out argument")
+	}
+	return t, nil
+}
+
+// mismatchedFields returns a message for each field of the struct type that
+// isn't one of the properties
+func mismatchedFields(t reflect.Type, properties []string) []string {
+	exact := make(map[string]bool, len(properties))
+	folded := make(map[string]string, len(properties))
+	for _, p := range properties {
+		exact[p] = true
+		folded[strings.ToLower(p)] = p
+	}
+
+	mismatched := []string{}
+	for _, p := range structProperties(t) {
+		if p.skip || exact[p.name] || p.name == nodeProperty {
+			continue
+		}
+		if name, ok := folded[strings.ToLower(p.name)]; ok {
+			mismatched = append(mismatched, fmt.Sprintf("Field %s: property %s has different case, the property is %s", p.field.Name, p.name, name))
+			continue
+		}
+		mismatched = append(mismatched, fmt.Sprintf("Field %s: %s is not a property of the class", p.field.Name, p.name))
+	}
+	return mismatched
+}
@@ -0,0 +1,42 @@
+package wmic
+
+import (
+	"strings"
+	"testing"
+)
+
+type misspeltService struct {
+	Name        string
+	DisplayName string `wmi:"Displayname"`
+	Status      string
+	Ignored     string `wmi:"-"`
+}
+
+func TestValidateStruct(t *testing.T) {
+
+	useRunner(t, &fakeRunner{stdout: serviceOutput})
+	mismatched, err := ValidateStruct("Win32_Service", &[]misspeltService{})
+	if err != nil {
+		t.Fatalf("validate failed: %s", err)
+	}
+	if len(mismatched) != 2 {
+		t.Fatalf("expected 2 mismatched fields, got %v", mismatched)
+	}
+	if !strings.Contains(mismatched[0], "different case, the property is DisplayName") {
+		t.Fatalf("expected a case mismatch for DisplayName, got %s", mismatched[0])
+	}
+	if !strings.Contains(mismatched[1], "Status is not a property") {
+		t.Fatalf("expected Status to be missing, got %s", mismatched[1])
+	}
+
+	mismatched, err = ValidateStruct("Win32_Service", struct{ Name, State string }{})
+	if err != nil || len(mismatched) != 0 {
+		t.Fatalf("expected no mismatched fields, got %v %v", mismatched, err)
+	}
+
+	_, err = ValidateStruct("Win32_Service", "")
+	if err == nil {
+		t.Fatalf("expected an error for a string")
+	}
+
+}
@@ -0,0 +1,65 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Watch queries the class every interval and sends each snapshot of the
+// records on the channel until ctx is done, Shutdown is called or the client
+// is closed, when the channel is closed. The
+// first snapshot is taken straight away. wmic is run for each snapshot rather
+// than with /EVERY, so it works with the PowerShell backend and a hung query
+// can't stop later snapshots. Each snapshot is a new slice, so it
+// can be kept after the next one arrives. A query that fails is skipped, use
+// WithOnComplete to see the errors. A snapshot isn't taken while the previous
+// one is waiting to be received
+func Watch[T any](ctx context.Context, class string, columns []string, where string, interval time.Duration, opts ...Option) (<-chan []T, error) {
+	if interval <= 0 {
+		return nil, errors.New("The watch interval must be positive")
+	}
+	_, _, _, err := outSlice(&[]T{})
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(append([]Option{WithColumns(columns...), WithWhere(where)}, opts...))
+	if err := checkTimeout(o.timeout()); err != nil {
+		return nil, err
+	}
+
+	snapshots := make(chan []T)
+	go func() {
+		defer close(snapshots)
+		ctx, cancel := withShutdown(ctx, o)
+		defer cancel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if snapshot, ok := watchSnapshot[T](ctx, class, o); ok {
+				select {
+				case snapshots <- snapshot:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return snapshots, nil
+}
+
+// watchSnapshot runs one query for Watch, limited to the timeout
+func watchSnapshot[T any](ctx context.Context, class string, o *QueryOptions) ([]T, bool) {
+	// The timeout is checked by Watch
+	ctx, cancel, _ := withTimeout(ctx, o.timeout())
+	defer cancel()
+
+	snapshot := []T{}
+	_, err := query(ctx, class, &snapshot, o)
+	return snapshot, err == nil
+}
@@ -0,0 +1,80 @@
+package wmic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots, err := Watch[win32Service](ctx, "Win32_Service", []string{"Name", "State"}, "State='Running'", time.Millisecond, WithRunner(f))
+	if err != nil {
+		t.Fatalf("watch failed: %s", err)
+	}
+	received := [][]win32Service{}
+	for s := range snapshots {
+		received = append(received, s)
+		if len(received) == 3 {
+			cancel()
+		}
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected the channel to close after cancelling, got %d snapshots", len(received))
+	}
+	received[0][0].Name = "changed"
+	if received[1][0].Name != "Spooler" || len(received[2]) != 2 {
+		t.Fatalf("expected each snapshot to be a new slice, got %v", received)
+	}
+	f.mu.Lock()
+	args := strings.Join(f.args[0], " ")
+	f.mu.Unlock()
+	if !strings.Contains(args, "WHERE ( State='Running' ) GET Name,State") {
+		t.Fatalf("unexpected args %s", args)
+	}
+
+}
+
+func TestWatchErrors(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := Watch[win32Service](ctx, "Win32_Service", nil, "", 0); err == nil {
+		t.Fatalf("expected an error for a zero interval")
+	}
+	if _, err := Watch[string](ctx, "Win32_Service", nil, "", time.Second); err == nil {
+		t.Fatalf("expected an error for a non struct type")
+	}
+
+	s := &sequenceRunner{fakes: []*fakeRunner{
+		{stderr: rpcUnavailable, err: errors.New("exit status 2147944122")},
+		{stdout: serviceOutput},
+	}}
+	var mu sync.Mutex
+	var failed []error
+	snapshots, err := Watch[*win32Service](ctx, "Win32_Service", nil, "", time.Millisecond, WithRunner(s),
+		WithOnComplete(func(class string, d time.Duration, rows int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed = append(failed, err)
+			}
+		}))
+	if err != nil {
+		t.Fatalf("watch failed: %s", err)
+	}
+	first := <-snapshots
+	cancel()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(first) != 2 || first[0].Name != "Spooler" || len(failed) != 1 || !errors.Is(failed[0], ErrRPCUnavailable) {
+		t.Fatalf("expected the failed snapshot to be skipped, got %v %v", first, failed)
+	}
+
+}
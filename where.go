@@ -0,0 +1,219 @@
+package wmic
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is a where clause with its values quoted and escaped, use String
+// to pass it to QueryWhere or WithCondition for QueryWith
+type Condition struct {
+	expr string
+	// compound is set for And and Or so they are parenthesized when nested
+	compound bool
+}
+
+// String returns the condition as a where clause
+func (c Condition) String() string {
+	return c.expr
+}
+
+// Where returns a condition comparing a property to a value, e.g.
+// Where("Name", "=", name). String values are quoted and escaped, numbers and
+// booleans are not
+func Where(property, operator string, value interface{}) Condition {
+	operator = strings.TrimSpace(operator)
+	if isWord(operator) {
+		// Keyword operators such as LIKE need surrounding spaces
+		operator = " " + operator + " "
+	}
+	return Condition{expr: property + operator + literal(value)}
+}
+
+// Eq returns a condition that the property equals the value
+func Eq(property string, value interface{}) Condition {
+	return Where(property, "=", value)
+}
+
+// Gt returns a condition that the property is greater than the value
+func Gt(property string, value interface{}) Condition {
+	return Where(property, ">", value)
+}
+
+// Lt returns a condition that the property is less than the value
+func Lt(property string, value interface{}) Condition {
+	return Where(property, "<", value)
+}
+
+// Like returns a condition that the property matches the pattern, which can
+// use the % and _ wildcards and [] character ranges. Use EscapeLike for text
+// in the pattern that should match literally
+func Like(property, pattern string) Condition {
+	return Where(property, "LIKE", pattern)
+}
+
+// Contains returns a condition that the property contains the substring,
+// which is matched literally
+func Contains(property, substring string) Condition {
+	return Like(property, "%"+EscapeLike(substring)+"%")
+}
+
+// EscapeLike escapes the LIKE wildcards in s so it matches literally, e.g.
+// 100% becomes 100[%]
+func EscapeLike(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '%', '_', '[':
+			b.WriteString("[" + string(r) + "]")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// And returns a condition that all the conditions are true
+func And(conds ...Condition) Condition {
+	return join(" AND ", conds)
+}
+
+// Or returns a condition that any of the conditions are true
+func Or(conds ...Condition) Condition {
+	return join(" OR ", conds)
+}
+
+// join combines the conditions with the operator, wrapping nested And and Or
+// conditions in parentheses
+func join(operator string, conds []Condition) Condition {
+	nonEmpty := []Condition{}
+	for _, c := range conds {
+		if c.expr != "" {
+			nonEmpty = append(nonEmpty, c)
+		}
+	}
+	if len(nonEmpty) == 1 {
+		return nonEmpty[0]
+	}
+	parts := []string{}
+	for _, c := range nonEmpty {
+		if c.compound {
+			parts = append(parts, "("+c.expr+")")
+		} else {
+			parts = append(parts, c.expr)
+		}
+	}
+	return Condition{expr: strings.Join(parts, operator), compound: len(parts) > 1}
+}
+
+// literal formats a value for a where clause. Numbers, including named number
+// types, aren't quoted as WMI compares a quoted number as a string
+func literal(value interface{}) string {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	case reflect.Bool:
+		if v.Bool() {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	if s, ok := value.(fmt.Stringer); ok {
+		return quote(s.String())
+	}
+	return quote(fmt.Sprint(value))
+}
+
+// quote returns s as a WQL string literal, escaping backslashes and quotes
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && r != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitWhere splits a where clause on spaces that aren't inside a quoted
+// string so values such as "Microsoft Office" stay as one argument
+func splitWhere(where string) []string {
+	parts := []string{}
+	var part strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range strings.TrimSpace(where) {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ' ':
+			if part.Len() > 0 {
+				parts = append(parts, part.String())
+				part.Reset()
+			}
+			continue
+		}
+		part.WriteRune(r)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
+
+// enclosed returns true if the whole where clause is inside one pair of
+// parentheses, e.g. (A=1 AND (B=2)) but not (A=1) AND (B=2). Parentheses in
+// quoted values are ignored
+func enclosed(where string) bool {
+	where = strings.TrimSpace(where)
+	if !strings.HasPrefix(where, "(") || !strings.HasSuffix(where, ")") {
+		return false
+	}
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, r := range where {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '(':
+			depth++
+		case quote == 0 && r == ')':
+			depth--
+			if depth == 0 {
+				// The first parenthesis closes, so it only encloses the
+				// clause if this is the end
+				return i == len(where)-1
+			}
+		}
+	}
+	return false
+}
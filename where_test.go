@@ -0,0 +1,101 @@
+package wmic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type processID uint32
+
+func TestWhere(t *testing.T) {
+
+	tests := []struct {
+		c    Condition
+		want string
+	}{
+		{Where("Name", "=", "Microsoft Office"), `Name="Microsoft Office"`},
+		{Where("Name", "=", `say "hi" \ bye`), `Name="say \"hi\" \\ bye"`},
+		{Where("ProcessId", ">", 1000), `ProcessId>1000`},
+		{Where("Started", "=", true), `Started=TRUE`},
+		{Where("Name", "like", "%chrome%"), `Name like "%chrome%"`},
+		{Eq("ProcessId", 4), `ProcessId=4`},
+		{Eq("Name", "x"), `Name="x"`},
+		{Eq("Name", "4"), `Name="4"`},
+		{Eq("ProcessId", processID(1068)), `ProcessId=1068`},
+		{Eq("Capacity", uint64(18446744073709551615)), `Capacity=18446744073709551615`},
+		{Gt("LoadPercentage", 1e6), `LoadPercentage>1000000`},
+		{Lt("Ratio", float32(0.5)), `Ratio<0.5`},
+		{Like("Name", "chrome%"), `Name LIKE "chrome%"`},
+		{Like("Name", "%"+EscapeLike("100%_[x]")+"%"), `Name LIKE "%100[%][_][[]x]%"`},
+		{Contains("CommandLine", `say "100%"`), `CommandLine LIKE "%say \"100[%]\"%"`},
+		{Contains("Name", "chrome"), `Name LIKE "%chrome%"`},
+	}
+	for _, test := range tests {
+		if got := test.c.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+}
+
+func TestSplitWhere(t *testing.T) {
+
+	got := splitWhere(Where("Name", "=", "Microsoft  Office").String() + "  AND State = 'Running \\' now'")
+	want := []string{`Name="Microsoft  Office"`, "AND", "State", "=", `'Running \' now'`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	for _, where := range []string{"", "   ", "\t "} {
+		args := buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), &QueryOptions{Where: where})
+		if got := strings.Join(args, " "); strings.Contains(got, "WHERE") {
+			t.Fatalf("expected no WHERE for %q, got %s", where, got)
+		}
+	}
+
+}
+
+func TestWhereParentheses(t *testing.T) {
+
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{"Name='Spooler'", "WHERE ( Name='Spooler' ) GET"},
+		{"(Name='Spooler')", "WHERE (Name='Spooler') GET"},
+		{"(A=1) AND (B=2)", "WHERE ( (A=1) AND (B=2) ) GET"},
+		{"A=1 AND (B=2 OR C=3)", "WHERE ( A=1 AND (B=2 OR C=3) ) GET"},
+		{"(A=1 OR B=2) AND C=3", "WHERE ( (A=1 OR B=2) AND C=3 ) GET"},
+		{"((A=1 OR B=2) AND (C=3))", "WHERE ((A=1 OR B=2) AND (C=3)) GET"},
+		{" (Name LIKE '%(x)') ", "WHERE (Name LIKE '%(x)') GET"},
+		{"(Name=')') AND (B=2)", "WHERE ( (Name=')') AND (B=2) ) GET"},
+	}
+	for _, test := range tests {
+		args := buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), &QueryOptions{Where: test.where})
+		if got := strings.Join(args, " "); !strings.Contains(got, test.want) {
+			t.Errorf("%q: got %s, want %s", test.where, got, test.want)
+		}
+	}
+
+}
+
+func TestConditionBuilder(t *testing.T) {
+
+	tests := []struct {
+		c    Condition
+		want string
+	}{
+		{And(Eq("A", 1), Or(Eq("B", 2), Eq("C", 3))), `A=1 AND (B=2 OR C=3)`},
+		{Or(And(Gt("A", 1), Lt("A", 5)), Like("Name", "%sql%")), `(A>1 AND A<5) OR Name LIKE "%sql%"`},
+		{And(Or(Eq("B", 2), Eq("C", 3))), `B=2 OR C=3`},
+		{And(Eq("A", "x")), `A="x"`},
+		{And(), ``},
+	}
+	for _, test := range tests {
+		if got := test.c.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+}
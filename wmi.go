@@ -4,24 +4,68 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"os/exec"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-var fieldCache = map[string]string{}
+// fieldCache holds the get list built for each struct type. It is keyed by
+// type rather than name so structs with the same name in different packages
+// don't collide, and is safe for concurrent queries
+var fieldCache sync.Map
 
+var timeType = reflect.TypeOf(time.Time{})
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+var restType = reflect.TypeOf(map[string]string{})
+
+var numberType = reflect.TypeOf(json.Number(""))
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+var bigFloatType = reflect.TypeOf(big.Float{})
+
+// AllColumns as the only column gets every property of the class, e.g. for
+// QueryMap
+const AllColumns = "*"
+
+// TIMEOUT_DEFAULT is the initial DefaultTimeout
 const TIMEOUT_DEFAULT = "30m"
 
+// DefaultTimeout is used by queries that don't set a timeout, zero means no
+// timeout. Set it before running any queries to change it for the whole
+// process
+var DefaultTimeout = 30 * time.Minute
+
+// MaxLineSize is the longest line of wmic output that can be parsed, values
+// longer than this fail the query with bufio.ErrTooLong
+var MaxLineSize = 4 * 1024 * 1024
+
 // RecordError holds information about an error for record in the WMI result
 type RecordError struct {
-	Class   string
-	Field   string
-	Line    int
+	Class string
+	Field string
+	// Record is the 1-based index of the record in the output
+	Record int
+	// Line is the 1-based line of the wmic /VALUE output the property is on,
+	// or 0 for other formats
+	Line int
+	// Value is the raw value of the property as wmic output it, empty for
+	// errors from an Unmarshaler
+	Value   string
 	Message string
 }
 
@@ -44,7 +88,32 @@ func (e *UnsupportedTypeError) Error() string {
 	return fmt.Sprintf("Field %s has an unsupported type %s", e.Field, e.Type)
 }
 
-// QueryAll returns all items with columns matching the out struct
+// Unmarshaler is implemented by types that decode a whole record themselves,
+// such as those with fields computed from several properties. fields holds
+// the raw value of each property in the record
+type Unmarshaler interface {
+	UnmarshalWMI(fields map[string]string) error
+}
+
+// ErrWmicNotFound is returned when the wmic executable can't be found, check
+// for it with errors.Is
+var ErrWmicNotFound = errors.New("wmic not found, it is deprecated and may not be installed on this version of Windows")
+
+// notFound wraps the error from running a missing wmic in ErrWmicNotFound
+func notFound(err error) error {
+	return fmt.Errorf("%w: %v", ErrWmicNotFound, err)
+}
+
+// ErrNoInstances is returned by QueryOne when no instances match
+var ErrNoInstances = errors.New("No instances match the query")
+
+// ErrMultipleInstances is returned by QueryOne when more than one instance matches
+var ErrMultipleInstances = errors.New("More than one instance matches the query")
+
+// QueryAll returns all items with columns matching the out struct. Fields are
+// matched to WMI properties by name, or by a wmi:"Property" struct tag if set;
+// fields tagged wmi:"-" are not queried. Blank values are assigned to string
+// fields (a *string is set to point to ""), other fields are left unset
 func QueryAll(class string, out interface{}) ([]RecordError, error) {
 	return Query(class, []string{}, "", out)
 }
@@ -53,6 +122,11 @@ func QueryAllWithTimeout(class string, out interface{}, timeout string) ([]Recor
 	return QueryWithTimeout(class, []string{}, "", out, timeout)
 }
 
+// QueryAllWithDuration is QueryAllWithTimeout with a time.Duration timeout
+func QueryAllWithDuration(class string, out interface{}, timeout time.Duration) ([]RecordError, error) {
+	return QueryWithDuration(class, []string{}, "", out, timeout)
+}
+
 // QueryColumns returns all items with specific columns
 func QueryColumns(class string, columns []string, out interface{}) ([]RecordError, error) {
 	return Query(class, columns, "", out)
@@ -62,7 +136,13 @@ func QueryColumnsWithTimeout(class string, columns []string, out interface{}, ti
 	return QueryWithTimeout(class, columns, "", out, timeout)
 }
 
-// QueryWhere returns all columns for where clause
+// QueryColumnsWithDuration is QueryColumnsWithTimeout with a time.Duration timeout
+func QueryColumnsWithDuration(class string, columns []string, out interface{}, timeout time.Duration) ([]RecordError, error) {
+	return QueryWithDuration(class, columns, "", out, timeout)
+}
+
+// QueryWhere returns all columns for where clause. The clause is passed to
+// wmic unescaped, use Where to build one from untrusted values
 func QueryWhere(class, where string, out interface{}) ([]RecordError, error) {
 	return Query(class, []string{}, where, out)
 }
@@ -71,15 +151,358 @@ func QueryWhereWithTimeout(class, where string, out interface{}, timeout string)
 	return QueryWithTimeout(class, []string{}, where, out, timeout)
 }
 
+// QueryWhereWithDuration is QueryWhereWithTimeout with a time.Duration timeout
+func QueryWhereWithDuration(class, where string, out interface{}, timeout time.Duration) ([]RecordError, error) {
+	return QueryWithDuration(class, []string{}, where, out, timeout)
+}
+
+// QueryOne populates the out struct pointer from the single instance matching
+// the where clause, e.g. for Win32_OperatingSystem. ErrNoInstances or
+// ErrMultipleInstances is returned unless exactly one instance matches. Fields
+// that fail to parse are left at their zero value
+func QueryOne(class string, where string, out interface{}) error {
+	return queryOne(class, where, out, nil)
+}
+
+// QueryInstance populates the out struct pointer from the instance at the WMI
+// object path, e.g. Win32_Service.Name="Spooler", which is quicker than a
+// where clause matching the key. An error matching ErrInstanceNotFound is
+// returned if there is no instance at the path
+func QueryInstance(path string, out interface{}) error {
+	err := queryOne(path, "", out, nil)
+	if errors.Is(err, ErrNoInstances) {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, path)
+	}
+	return err
+}
+
+// queryOne runs QueryOne with extra options
+func queryOne(class string, where string, out interface{}, opts []Option) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("You must provide a pointer to a struct to the out argument")
+	}
+	results := reflect.New(reflect.SliceOf(v.Elem().Type()))
+	_, err := QueryWith(class, results.Interface(), append([]Option{WithWhere(where)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	switch results.Elem().Len() {
+	case 0:
+		return ErrNoInstances
+	case 1:
+		v.Elem().Set(results.Elem().Index(0))
+		return nil
+	}
+	return ErrMultipleInstances
+}
+
+// keyProperties is a key property of common classes, which is all that is
+// needed to count instances or check that one exists
+var keyProperties = map[string]string{
+	"win32_bios":                        "Name",
+	"win32_computersystem":              "Name",
+	"win32_diskdrive":                   "DeviceID",
+	"win32_diskpartition":               "DeviceID",
+	"win32_group":                       "SID",
+	"win32_logicaldisk":                 "DeviceID",
+	"win32_networkadapter":              "DeviceID",
+	"win32_networkadapterconfiguration": "Index",
+	"win32_operatingsystem":             "Name",
+	"win32_pnpentity":                   "DeviceID",
+	"win32_printer":                     "DeviceID",
+	"win32_process":                     "Handle",
+	"win32_processor":                   "DeviceID",
+	"win32_quickfixengineering":         "HotFixID",
+	"win32_service":                     "Name",
+	"win32_share":                       "Name",
+	"win32_thread":                      "Handle",
+	"win32_useraccount":                 "SID",
+	"win32_volume":                      "DeviceID",
+}
+
+// countColumn returns a lightweight property to get when only the number of
+// instances matters, so large properties such as CommandLine aren't read. It
+// is a key property of a known class, otherwise the relative path which every
+// class has and is made from the key properties
+func countColumn(class string) string {
+	if key, ok := keyProperties[strings.ToLower(class)]; ok {
+		return key
+	}
+	return "__RELPATH"
+}
+
+// QueryCount returns the number of instances matching the where clause
+func QueryCount(class, where string) (int, error) {
+	return queryCount(class, where, nil)
+}
+
+// queryCount runs QueryCount with extra options
+func queryCount(class, where string, opts []Option) (int, error) {
+	out := []rawRecord{}
+	opts = append([]Option{WithColumns(countColumn(class)), WithWhere(where)}, opts...)
+	_, err := QueryWith(class, &out, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return len(out), nil
+}
+
+// Exists returns true if any instance matches the where clause. wmic is
+// stopped as soon as the first instance is read
+func Exists(class, where string, opts ...Option) (bool, error) {
+	ctx, cancel, err := withTimeout(context.Background(), newOptions(opts).timeout())
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	return ExistsContext(ctx, class, where, opts...)
+}
+
+// ExistsContext is Exists with a context, wmic is killed if the context is
+// done before the first instance is read
+func ExistsContext(ctx context.Context, class, where string, opts ...Option) (bool, error) {
+	it, err := QueryIter(ctx, class, []string{countColumn(class)}, where, &rawRecord{}, opts...)
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+
+	if it.Next() {
+		return true, nil
+	}
+	return false, it.Err()
+}
+
+// rawRecord keeps the raw property values of an instance for QueryMap
+type rawRecord struct {
+	fields map[string]string
+}
+
+func (r *rawRecord) UnmarshalWMI(fields map[string]string) error {
+	r.fields = fields
+	return nil
+}
+
+// QueryMap returns each instance as a map of property name to the raw string
+// value, for when there is no struct to decode into. All the properties are
+// returned if columns is empty
+func QueryMap(class string, columns []string, where string) ([]map[string]string, error) {
+	return queryMap(class, columns, where, nil)
+}
+
+// queryMap runs QueryMap with extra options
+func queryMap(class string, columns []string, where string, opts []Option) ([]map[string]string, error) {
+	if len(columns) == 0 {
+		columns = []string{AllColumns}
+	}
+	out := []rawRecord{}
+	opts = append([]Option{WithColumns(columns...), WithWhere(where)}, opts...)
+	_, err := QueryWith(class, &out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]string, len(out))
+	for i, r := range out {
+		result[i] = r.fields
+	}
+	return result, nil
+}
+
+// QueryAssociators returns the instances of resultClass associated with the
+// instances of the class matching the where clause, e.g. the Win32_DiskDrive
+// of a Win32_DiskPartition, like an ASSOCIATORS OF query. Every associated
+// instance is returned if resultClass is empty. wmic's ASSOC output can't be
+// parsed reliably so this always uses PowerShell's Get-CimAssociatedInstance
+func QueryAssociators(class, where, resultClass string, out interface{}) ([]RecordError, error) {
+	return QueryWith(class, out, WithWhere(where), WithBackend(BackendPowerShell), func(o *QueryOptions) {
+		o.associators = true
+		o.resultClass = resultClass
+	})
+}
+
 // Query returns a WMI query with the given parameters
 func Query(class string, columns []string, where string, out interface{}) ([]RecordError, error) {
-	return QueryWithTimeout(class, []string{}, where, out, TIMEOUT_DEFAULT)
+	return QueryWith(class, out, WithColumns(columns...), WithWhere(where))
 }
 
 func QueryWithTimeout(class string, columns []string, where string, out interface{}, timeout string) ([]RecordError, error) {
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return []RecordError{}, err
+	}
 
-	recordErrors := []RecordError{}
+	return QueryWithDuration(class, columns, where, out, duration)
+}
+
+// QueryWithDuration is QueryWithTimeout with a time.Duration timeout, so an
+// invalid timeout can't fail at runtime
+func QueryWithDuration(class string, columns []string, where string, out interface{}, timeout time.Duration) ([]RecordError, error) {
+	return QueryWith(class, out, WithColumns(columns...), WithWhere(where), WithTimeout(timeout))
+}
+
+// QueryContext returns a WMI query with the given parameters, the wmic process
+// is killed if the context is cancelled before the query completes
+func QueryContext(ctx context.Context, class string, columns []string, where string, out interface{}) ([]RecordError, error) {
+	return query(ctx, class, out, &QueryOptions{Columns: columns, Where: where})
+}
+
+// query runs wmic for the class with the given options and decodes the result
+// into out, calling OnComplete when done
+func query(ctx context.Context, class string, out interface{}, o *QueryOptions) ([]RecordError, error) {
+	if o.OnComplete == nil {
+		return queryRecords(ctx, class, out, o)
+	}
+	start := time.Now()
+	existing := 0
+	if outerValue, _, _, err := outSlice(out); err == nil && o.Append {
+		existing = outerValue.Len()
+	}
+	recordErrors, err := queryRecords(ctx, class, out, o)
+	rows := 0
+	var nodeErrors NodeErrors
+	if err == nil || errors.As(err, &nodeErrors) {
+		outerValue, _, _, _ := outSlice(out)
+		rows = outerValue.Len() - existing
+	}
+	o.OnComplete(class, time.Since(start), rows, err)
+	return recordErrors, err
+}
+
+// queryRecords runs the query against one or more nodes and sorts the results
+func queryRecords(ctx context.Context, class string, out interface{}, o *QueryOptions) ([]RecordError, error) {
+	ctx, cancel := withShutdown(ctx, o)
+	defer cancel()
+	if ctx.Err() != nil {
+		return []RecordError{}, ctx.Err()
+	}
+
+	outerValue, innerType, _, err := outSlice(out)
+	if err != nil {
+		return []RecordError{}, err
+	}
+
+	err = checkNamespace(o.Namespace)
+	if err != nil {
+		return []RecordError{}, err
+	}
+	err = checkQuery(class, innerType, o)
+	if err != nil {
+		return []RecordError{}, err
+	}
+
+	var existing reflect.Value
+	if o.Append {
+		// Query into a new slice and append it to the records already in out
+		existing = reflect.ValueOf(outerValue.Interface())
+	}
+
+	var recordErrors []RecordError
+	if len(o.Nodes) > 1 {
+		recordErrors, err = queryNodes(ctx, class, outerValue, innerType, o)
+	} else {
+		recordErrors, err = runRetry(ctx, class, out, innerType, o)
+		if err == nil && len(o.Nodes) == 1 {
+			setNode(outerValue, o.Nodes[0])
+		}
+	}
+	var nodeErrors NodeErrors
+	if err == nil || errors.As(err, &nodeErrors) {
+		if sortErr := sortRecords(outerValue, o.OrderBy); sortErr != nil {
+			err = sortErr
+		}
+	}
+	if o.Append {
+		if err != nil {
+			// Keep the existing records without any from a failed query
+			outerValue.Set(existing)
+		} else {
+			outerValue.Set(reflect.AppendSlice(existing, outerValue))
+		}
+	}
+	return recordErrors, err
+}
+
+// runOnce executes a single wmic process and decodes the output into out. It
+// doesn't add a timeout or retry, the process is killed when ctx is done
+func runOnce(ctx context.Context, class string, out interface{}, innerType reflect.Type, o *QueryOptions) ([]RecordError, error) {
+	args := buildArgs(class, innerType, o)
+
+	if o.Backend == BackendPowerShell {
+		return runPowerShell(ctx, class, out, innerType, o)
+	}
+
+	if o.Debug != nil {
+		o.Debug(redactArgs(args))
+	}
+
+	stdout, stderr, err := runWmic(ctx, args, o)
+	stdout, stderr = transcode(stdout, o.Encoding), transcode(stderr, o.Encoding)
+	if errors.Is(err, exec.ErrNotFound) {
+		if o.Backend == BackendAuto {
+			// wmic has been removed from recent versions of Windows
+			recordErrors, psErr := runPowerShell(ctx, class, out, innerType, o)
+			if errors.Is(psErr, exec.ErrNotFound) {
+				return recordErrors, notFound(err)
+			}
+			return recordErrors, psErr
+		}
+		return []RecordError{}, notFound(err)
+	}
+	if noInstances(stdout) || noInstances(stderr) {
+		// Nothing matched, return an empty slice
+		return decode(bytes.NewReader(nil), class, out, o)
+	}
+	if o.IgnoreMissing && len(o.Columns) == 0 && errors.Is(newWmicError(stderr, err), ErrInvalidQuery) {
+		// A struct field isn't a property of this class, get all the
+		// properties instead and ignore those without a field
+		all := *o
+		all.Columns = []string{AllColumns}
+		return runOnce(ctx, class, out, innerType, &all)
+	}
+	err = cmdError(err, stdout, stderr)
+	if err != nil {
+		return []RecordError{}, err
+	}
+
+	if o.Decoder != nil {
+		return o.Decoder(stdout, out)
+	}
+	if o.Format == FormatXML {
+		return decodeRecords(newXMLReader(bytes.NewReader(stdout)), class, out, o)
+	}
+	return decode(bytes.NewReader(stdout), class, out, o)
+}
+
+// cmdError returns the error for a finished wmic process from the error
+// returned by the command and its stderr output, which is parsed into a
+// WmicError. A nonzero exit without stderr output, including an HRESULT, is a
+// WmicError with the exit code and stdout so the failure can be diagnosed
+func cmdError(err error, stdout, stderr []byte) error {
+	if noInstances(stderr) {
+		return nil
+	}
+	if len(bytes.TrimSpace(stderr)) > 0 {
+		return newWmicError(stderr, err)
+	}
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) && exit.ExitCode() != 0 {
+		e := newWmicError(stderr, err)
+		e.Output = string(stdout)
+		return e
+	}
+	return err
+}
+
+// noInstances returns true if the output is the message wmic prints when no
+// instances match the query
+func noInstances(b []byte) bool {
+	return strings.EqualFold(strings.TrimSpace(string(b)), "No Instance(s) Available.")
+}
 
+// outSlice checks that out is a slice of structs (or struct pointers) and
+// returns the slice value, the struct type and whether the elements are pointers
+func outSlice(out interface{}) (reflect.Value, reflect.Type, bool, error) {
 	// Get the outer type (needs to be a slice)
 	outerValue := reflect.ValueOf(out)
 	if outerValue.Kind() == reflect.Ptr {
@@ -87,7 +510,7 @@ func QueryWithTimeout(class string, columns []string, where string, out interfac
 	}
 
 	if outerValue.Kind() != reflect.Slice {
-		return recordErrors, fmt.Errorf("You must provide a slice to the out argument")
+		return outerValue, nil, false, fmt.Errorf("You must provide a slice to the out argument")
 	}
 
 	// Get the inner type of the slice
@@ -100,105 +523,66 @@ func QueryWithTimeout(class string, columns []string, where string, out interfac
 	}
 
 	if innerType.Kind() != reflect.Struct {
-		return recordErrors, fmt.Errorf("You must provide a struct as the type of the out slice")
+		return outerValue, nil, false, fmt.Errorf("You must provide a struct as the type of the out slice")
 	}
 
-	query := []string{"PATH", class}
-	if where != "" {
-		parts := strings.Split(strings.TrimSpace(where), " ")
-		query = append(query, "WHERE")
-		if !strings.HasPrefix(parts[0], "(") {
-			query = append(query, "(")
-		}
-		query = append(query, parts...)
-		if !strings.HasSuffix(parts[len(parts)-1], ")") {
-			query = append(query, ")")
-		}
-	}
-	query = append(query, "GET")
+	return outerValue, innerType, innerTypeIsPointer, nil
+}
 
-	// If the column list is empty use the struct to create the get list
-	if len(columns) == 0 {
-		structName := innerType.Name()
-		if val, ok := fieldCache[structName]; ok {
-			query = append(query, val)
-		} else {
-			cols := []string{}
-			for i := 0; i < innerType.NumField(); i++ {
-				n := innerType.Field(i).Name
-				cols = append(cols, n)
-			}
-			colString := strings.Join(cols, ",")
-			fieldCache[structName] = colString
-			query = append(query, colString)
-		}
-	} else {
-		query = append(query, strings.Join(columns, ","))
-	}
-	query = append(query, "/format:rawxml")
-	query = append(query, "/VALUE")
+// DecodeValue parses previously captured wmic /VALUE output into the out
+// slice, as a query would. Options such as WithLenient and WithIgnoreMissing
+// apply to the decoding, the rest are ignored
+func DecodeValue(data []byte, out interface{}, opts ...Option) ([]RecordError, error) {
+	return DecodeValueReader(bytes.NewReader(data), out, opts...)
+}
 
-	duration, errParse := time.ParseDuration(timeout)
-	if errParse != nil {
-		return recordErrors, errParse
+// DecodeValueReader parses wmic /VALUE output from r into the out slice
+func DecodeValueReader(r io.Reader, out interface{}, opts ...Option) ([]RecordError, error) {
+	_, innerType, _, err := outSlice(out)
+	if err != nil {
+		return []RecordError{}, err
 	}
+	o := newOptions(opts)
+	return decode(transcodeReader(r, o.Encoding), innerType.Name(), out, o)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
-	defer cancel()
+// decode parses wmic /VALUE output into the out slice
+func decode(r io.Reader, class string, out interface{}, o *QueryOptions) ([]RecordError, error) {
+	return decodeRecords(newRecordReader(r), class, out, o)
+}
+
+// recordSource returns the properties of each record in turn, and io.EOF when
+// there are no more records
+type recordSource interface {
+	next() ([]property, error)
+}
+
+// decodeRecords decodes each record from the source into the out slice
+func decodeRecords(records recordSource, class string, out interface{}, o *QueryOptions) ([]RecordError, error) {
+
+	recordErrors := []RecordError{}
 
-	cmd := exec.CommandContext(ctx, "wmic", query...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+	outerValue, innerType, innerTypeIsPointer, err := outSlice(out)
 	if err != nil {
 		return recordErrors, err
 	}
-	if stderr.Len() > 0 {
-		return recordErrors, errors.New(string(stderr.Bytes()))
-	}
 
 	result := make([]interface{}, 0)
 
-	// Loop over the string
-	str := string(stdout.Bytes())
-	scanner := bufio.NewScanner(strings.NewReader(str))
-	item := reflect.New(innerType).Interface()
-	contentStarted := false
-	line := 1
-	for scanner.Scan() {
-		s := strings.TrimSpace(scanner.Text())
-		if s == "" {
-			if contentStarted {
-				line++
-				result = append(result, item)
-				item = reflect.New(innerType).Interface()
-				contentStarted = false
-			}
-		} else {
-			contentStarted = true
-			parts := strings.SplitN(s, "=", 2)
-			if len(parts) == 2 {
-				param := parts[0]
-				val := strings.TrimSpace(parts[1])
-				if val != "" {
-					err = set(param, val, item)
-					if err != nil {
-						if _, ok := err.(*FieldError); ok {
-							return recordErrors, err
-						} else if _, ok := err.(*UnsupportedTypeError); ok {
-							return recordErrors, err
-						}
-						// Error that allows continuation
-						recordErrors = append(recordErrors, RecordError{Class: class, Field: param, Line: line, Message: err.Error()})
-					}
-				}
-			}
+	for {
+		props, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return recordErrors, err
+		}
+		item := reflect.New(innerType).Interface()
+		errs, err := decodeRecord(class, len(result)+1, props, item, o)
+		recordErrors = append(recordErrors, errs...)
+		if err != nil {
+			return recordErrors, err
 		}
-	}
-
-	if contentStarted {
-		// Add remaining item if there is one
 		result = append(result, item)
 	}
 
@@ -218,67 +602,1104 @@ func QueryWithTimeout(class string, columns []string, where string, out interfac
 	return recordErrors, nil
 }
 
-func set(field, s string, item interface{}) error {
+// decodeRecord sets the properties of a record on the item struct pointer.
+// Missing fields and unsupported types stop decoding unless the options are
+// lenient, values that fail to parse are returned as RecordErrors. If the
+// item is an Unmarshaler it decodes the record itself and any error is
+// returned as a RecordError
+func decodeRecord(class string, record int, props []property, item interface{}, o *QueryOptions) ([]RecordError, error) {
+	recordErrors := []RecordError{}
+	if u, ok := item.(Unmarshaler); ok {
+		fields := make(map[string]string, len(props))
+		for _, p := range props {
+			fields[p.name] = p.value
+		}
+		err := u.UnmarshalWMI(fields)
+		if err != nil {
+			recordErrors = append(recordErrors, RecordError{Class: class, Record: record, Line: firstLine(props), Message: err.Error()})
+		}
+		return recordErrors, nil
+	}
+	for _, p := range props {
+		err := set(p, item, o)
+		if _, ok := err.(*FieldError); ok && o.IgnoreMissing {
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*FieldError); ok && !o.Lenient {
+				return recordErrors, err
+			} else if _, ok := err.(*UnsupportedTypeError); ok && !o.Lenient {
+				return recordErrors, err
+			}
+			// Error that allows continuation
+			recordErrors = append(recordErrors, RecordError{Class: class, Field: p.name, Record: record, Line: p.line, Value: p.value, Message: err.Error()})
+		}
+	}
+	return recordErrors, nil
+}
+
+// property is a name=value line from wmic output
+type property struct {
+	name  string
+	value string
+	// elems are the elements of an array value when the output has them
+	// separately, as RAWXML and JSON do, so elements can contain commas and
+	// quotes. value is then the elements in wmic's {"a","b"} form
+	elems []string
+	// line is the line of /VALUE output the property starts on
+	line int
+}
+
+// firstLine returns the line of the first property of a record, or 0
+func firstLine(props []property) int {
+	if len(props) == 0 {
+		return 0
+	}
+	return props[0].line
+}
+
+// recordReader reads records from wmic /VALUE output, each record is a block
+// of name=value lines separated by blank lines. Lines that aren't name=value
+// continue the value of the previous property
+type recordReader struct {
+	scanner *bufio.Scanner
+	// line is the number of lines read so far
+	line int
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{scanner: newScanner(r)}
+}
+
+// next returns the properties of the next record, or io.EOF when there are no
+// more records
+func (rr *recordReader) next() ([]property, error) {
+	props := []property{}
+	contentStarted := false
+	for rr.scanner.Scan() {
+		rr.line++
+		s := strings.TrimSpace(rr.scanner.Text())
+		if s == "" {
+			if contentStarted {
+				break
+			}
+			continue
+		}
+		if noInstances([]byte(s)) {
+			continue
+		}
+		contentStarted = true
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) == 2 {
+			parts[0] = trimName(parts[0])
+		}
+		if len(parts) == 2 && isPropertyName(parts[0]) {
+			props = append(props, property{name: parts[0], value: strings.TrimSpace(parts[1]), line: rr.line})
+		} else if len(props) > 0 {
+			// A value spanning several lines, such as a CommandLine
+			last := &props[len(props)-1]
+			last.value += "\n" + s
+		}
+	}
+	if err := rr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !contentStarted {
+		return nil, io.EOF
+	}
+	return props, nil
+}
+
+// trimName removes the spaces around a property name, including non-breaking
+// and zero width spaces some locales and aliases pad names with
+func trimName(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '\u200b' || r == '\ufeff'
+	})
+}
+
+// isPropertyName returns true if s can be the name of a property, so a
+// continuation line containing = isn't taken as a new property
+func isPropertyName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdentifier returns true if s is a valid WMI class or property name
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkClass returns an error if the class isn't a valid class or alias name,
+// so a mistyped class fails before wmic is started. The keys of an object
+// path such as Win32_Service.Name="Spooler" aren't checked
+func checkClass(class string) error {
+	if class == "" {
+		return errors.New("You must provide a class")
+	}
+	name := class
+	if i := strings.IndexAny(class, ".="); i >= 0 {
+		name = class[:i]
+	}
+	if !isIdentifier(name) {
+		return fmt.Errorf("Class %q is not a valid class name", class)
+	}
+	return nil
+}
+
+// checkColumns returns an error if a column isn't a valid property name or
+// AllColumns
+func checkColumns(columns []string) error {
+	for _, c := range columns {
+		if c != AllColumns && !isIdentifier(c) {
+			return fmt.Errorf("Column %q is not a valid property name", c)
+		}
+	}
+	return nil
+}
+
+// checkQuery checks the class and the columns of the GET list, built from the
+// struct type if no columns are set. The class of a WQL query isn't checked
+// as it can be empty, e.g. for ASSOCIATORS OF
+func checkQuery(class string, innerType reflect.Type, o *QueryOptions) error {
+	if o.wql == "" {
+		if err := checkClass(class); err != nil {
+			return err
+		}
+	}
+	list := getList(innerType, o.Columns)
+	if list == "" && len(o.Columns) == 0 {
+		// A struct without properties to get
+		return nil
+	}
+	return checkColumns(strings.Split(list, ","))
+}
+
+// checkNamespace returns an error if the namespace contains anything other than
+// letters, digits, underscores and path separators
+func checkNamespace(namespace string) error {
+	for _, r := range namespace {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\\' || r == '/') {
+			return fmt.Errorf("Namespace %s contains an invalid character %q", namespace, r)
+		}
+	}
+	return nil
+}
+
+// formatNamespace returns the namespace in the form \\root\wmi, accepting
+// root\wmi and root/wmi
+func formatNamespace(namespace string) string {
+	namespace = strings.Trim(strings.Replace(namespace, "/", "\\", -1), "\\")
+	return "\\\\" + namespace
+}
+
+// redactArgs returns a copy of the wmic arguments with the password hidden,
+// use this for anything that shows the command line
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, a := range args {
+		if strings.HasPrefix(strings.ToUpper(a), "/PASSWORD:") {
+			a = a[:len("/PASSWORD:")] + "\"********\""
+		}
+		redacted[i] = a
+	}
+	return redacted
+}
+
+// newScanner returns a line scanner over wmic output, with carriage returns
+// stripped and a buffer large enough that long values aren't truncated
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(utf8Reader(r))
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	scanner.Split(scanLines)
+	return scanner
+}
+
+// utf8Reader removes any byte order mark from wmic output, converting UTF-16
+// output to UTF-8
+func utf8Reader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(3); bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}) {
+		br.Discard(3)
+	} else if bytes.HasPrefix(b, []byte{0xFF, 0xFE}) {
+		br.Discard(2)
+		return &utf16Reader{r: br}
+	}
+	return br
+}
+
+// scanLines is a bufio.SplitFunc that splits on \n and removes every \r, as
+// wmic terminates lines with \r\r\n
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	if bytes.IndexByte(token, '\r') >= 0 {
+		token = bytes.Replace(token, []byte{'\r'}, nil, -1)
+	}
+	return advance, token, err
+}
+
+// utf16Reader converts little-endian UTF-16 to UTF-8
+type utf16Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		r, err := u.readUnit()
+		if err != nil {
+			return 0, err
+		}
+		if utf16.IsSurrogate(r) {
+			r2, err := u.readUnit()
+			if err != nil {
+				return 0, err
+			}
+			r = utf16.DecodeRune(r, r2)
+		}
+		u.buf = utf8.AppendRune(u.buf, r)
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+func (u *utf16Reader) readUnit() (rune, error) {
+	lo, err := u.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	hi, err := u.r.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return rune(uint16(lo) | uint16(hi)<<8), nil
+}
+
+// buildArgs returns the wmic arguments for a query. If no columns are set the
+// GET list is built from the fields of the struct type
+func buildArgs(class string, innerType reflect.Type, o *QueryOptions) []string {
+	query := pathArgs(class, o)
+	query = append(query, "GET")
+
+	if list := getList(innerType, o.Columns); list != AllColumns {
+		// Without a list wmic returns all the properties
+		query = append(query, list)
+	}
+	if o.Format == FormatXML {
+		query = append(query, "/format:rawxml")
+	} else {
+		// The parser reads the name=value lines of the /VALUE format
+		query = append(query, "/VALUE")
+	}
+
+	return query
+}
+
+// pathArgs returns the wmic arguments up to the verb, selecting the instances
+// of the class matching the where clause
+func pathArgs(class string, o *QueryOptions) []string {
+	query := []string{}
+	if len(o.Nodes) > 0 {
+		query = append(query, "/NODE:\""+strings.Join(o.Nodes, "\",\"")+"\"")
+	}
+	if o.User != "" {
+		query = append(query, "/USER:\""+o.User+"\"")
+		query = append(query, "/PASSWORD:\""+o.Password+"\"")
+	}
+	if o.Namespace != "" {
+		query = append(query, "/NAMESPACE:"+formatNamespace(o.Namespace))
+	}
+	if o.NonInteractive {
+		query = append(query, interactiveOff)
+	}
+	if o.FailFast {
+		query = append(query, failFastOn)
+	}
+	if o.Alias {
+		query = append(query, class)
+	} else {
+		query = append(query, "PATH", class)
+	}
+	if parts := splitWhere(o.Where); len(parts) > 0 {
+		query = append(query, "WHERE")
+		if enclosed(o.Where) {
+			query = append(query, parts...)
+		} else {
+			query = append(query, "(")
+			query = append(query, parts...)
+			query = append(query, ")")
+		}
+	}
+	return query
+}
+
+// getList returns the comma separated properties to get. If the column list is
+// empty the struct is used to create the get list, which is cached per type
+func getList(innerType reflect.Type, columns []string) string {
+	if len(columns) > 0 {
+		return strings.Join(columns, ",")
+	}
+	if val, ok := fieldCache.Load(innerType); ok {
+		return val.(string)
+	}
+	cols := []string{}
+	for _, p := range structProperties(innerType) {
+		if p.skip || p.name == nodeProperty {
+			continue
+		}
+		cols = append(cols, p.name)
+	}
+	colString := strings.Join(cols, ",")
+	fieldCache.Store(innerType, colString)
+	return colString
+}
+
+func set(p property, item interface{}, o *QueryOptions) error {
+	field, s := p.name, p.value
 	v := reflect.ValueOf(item)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
-	f := v.FieldByName(field)
+	f, opts, skip := fieldByProperty(v, field, o.IgnoreCase)
+	if skip {
+		return nil
+	}
 	if !f.IsValid() {
+		if rest := fieldsOf(v.Type()).rest; rest != nil {
+			return setRest(field, s, fieldByIndex(v, rest, true))
+		}
 		return &FieldError{Field: field}
 	}
+	if p.elems != nil && isList(f.Type()) {
+		return setElems(field, p.elems, f, opts)
+	}
+	s, err := opts.enum(s)
+	if err != nil {
+		return err
+	}
+	kind := numberKind(f.Type())
+	if opts.has("number") && kind != reflect.Invalid {
+		s = leadingNumber(s, o, opts.has("hex"))
+	}
+	if o.GroupSeparator != "" && kind != reflect.Invalid {
+		s = strings.Replace(s, o.GroupSeparator, "", -1)
+	}
+	if o.WrapNegative && isUint(kind) && strings.HasPrefix(s, "-") {
+		s = wrapNegative(s, elemType(f.Type()).Bits(), opts.has("hex"))
+	}
+	if base, ok := opts.byteBase(); ok && kind != reflect.Invalid {
+		if o.DecimalSeparator != "" {
+			s = normalizeFloat(s, o.DecimalSeparator)
+		}
+		if s != "" {
+			size, err := ParseByteSize(s, base)
+			if err != nil {
+				return parseError(field, s, f)
+			}
+			s = strconv.FormatUint(size, 10)
+		}
+	} else if o.DecimalSeparator != "" && (kind == reflect.Float32 || kind == reflect.Float64) {
+		s = normalizeFloat(s, o.DecimalSeparator)
+	}
+	return setValue(field, s, f, opts)
+}
+
+// setRest adds a property without a field to the map field tagged
+// wmi:",rest"
+func setRest(field, s string, f reflect.Value) error {
+	if f.Type() != restType {
+		return &UnsupportedTypeError{Field: field, Type: f.Type().String()}
+	}
+	if f.IsNil() {
+		f.Set(reflect.MakeMap(restType))
+	}
+	f.SetMapIndex(reflect.ValueOf(field), reflect.ValueOf(s))
+	return nil
+}
+
+// setValue parses s into the field value f. Pointer fields are allocated and
+// only assigned once the value has parsed, so absent values leave them nil.
+// Types implementing encoding.TextUnmarshaler parse themselves. An empty value
+// is assigned to string fields, other fields are left at their zero value
+func setValue(field, s string, f reflect.Value, opts tagOptions) error {
+	if s == "" && !emptyAllowed(f.Type()) {
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		p := reflect.New(f.Type().Elem())
+		err := setValue(field, s, p.Elem(), opts)
+		if err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+	if f.Type() == timeType {
+		return setTime(field, s, f, opts)
+	}
+	if f.Type() == numberType {
+		return setNumber(field, s, f)
+	}
+	if f.Type() == bigIntType {
+		return setBigInt(field, s, f, opts.has("hex"))
+	}
+	if f.Type() == bigFloatType {
+		return setBigFloat(field, s, f)
+	}
+	if f.CanAddr() {
+		if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
 	switch f.Kind() {
 	case reflect.String:
 		return setString(s, f)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return setIntN(s, f, f.Type().Bits())
+		return setIntN(field, s, f, f.Type().Bits(), opts.has("hex"))
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return setUintN(s, f, f.Type().Bits())
+		return setUintN(field, s, f, f.Type().Bits(), opts.has("hex"))
 	case reflect.Float32, reflect.Float64:
-		return setFloatN(s, f, f.Type().Bits())
+		return setFloatN(field, s, f, f.Type().Bits())
 	case reflect.Bool:
-		return setBool(s, f)
+		return setBool(field, s, f)
+	case reflect.Slice:
+		return setSlice(field, s, f, opts)
+	case reflect.Array:
+		return setArray(field, s, f, opts)
 	}
 	return &UnsupportedTypeError{Field: field, Type: f.Kind().String()}
 }
 
+// emptyAllowed returns true if an empty value is assigned to a field of the
+// type rather than leaving it unset, which is the case for strings and string
+// pointers other than json.Number
+func emptyAllowed(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String && t != numberType && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// propertyName returns the WMI property name for a struct field, using the wmi
+// tag if present. The bool is false if the field is excluded with wmi:"-"
+func propertyName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("wmi")
+	if tag == "-" {
+		return "", false
+	}
+	name, _ := parseTag(tag)
+	if name == "" {
+		return f.Name, true
+	}
+	return name, true
+}
+
+// tagOptions are the options following the name in a wmi tag, e.g. hex in
+// wmi:"Address,hex"
+type tagOptions []string
+
+// parseTag splits a wmi tag into the property name and its options
+func parseTag(tag string) (string, tagOptions) {
+	parts := strings.Split(tag, ",")
+	return parts[0], tagOptions(parts[1:])
+}
+
+// has returns true if the option is set
+func (o tagOptions) has(option string) bool {
+	for _, opt := range o {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the value of a name=value option
+func (o tagOptions) value(name string) (string, bool) {
+	for _, opt := range o {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// leadingNumber returns the number at the start of a value with a unit or
+// annotation after it, for fields with the number option, e.g. 5 from
+// "5 (running)" with wmi:"Status,number". The number can contain the decimal
+// and group separators, and hex digits if the field is tagged hex
+func leadingNumber(s string, o *QueryOptions, hex bool) string {
+	separators := "." + o.DecimalSeparator + o.GroupSeparator
+	end := 0
+	for i, r := range s {
+		digit := r >= '0' && r <= '9'
+		sign := i == 0 && (r == '-' || r == '+')
+		hexDigit := hex && (strings.ContainsRune("abcdefABCDEF", r) || (i == 1 && (r == 'x' || r == 'X')))
+		if !digit && !sign && !hexDigit && !(end > 0 && strings.ContainsRune(separators, r)) {
+			break
+		}
+		end = i + utf8.RuneLen(r)
+	}
+	if end == 0 {
+		// Leave a value without a number to fail parsing
+		return s
+	}
+	return strings.TrimRight(s[:end], separators)
+}
+
+// enum translates a raw value to its name with the enum option, e.g.
+// wmi:"DriveType,enum=removable:2;fixed:3;network:4". Values that aren't in the
+// enum are returned as is, or are an error if the strict option is set
+func (o tagOptions) enum(s string) (string, error) {
+	spec, ok := o.value("enum")
+	if !ok {
+		return s, nil
+	}
+	for _, pair := range strings.Split(spec, ";") {
+		name, raw, ok := strings.Cut(pair, ":")
+		if ok && raw == s {
+			return name, nil
+		}
+	}
+	if o.has("strict") {
+		return "", fmt.Errorf("Value %s is not in the enum", s)
+	}
+	return s, nil
+}
+
+// fieldByProperty finds the struct field for a WMI property name and the
+// options from its tag. skip is true if the property maps to a field excluded
+// with wmi:"-"
+func fieldByProperty(v reflect.Value, property string, ignoreCase bool) (f reflect.Value, opts tagOptions, skip bool) {
+	fields := fieldsOf(v.Type())
+	byName, skipped := fields.byName, fields.skipped
+	if ignoreCase {
+		byName, skipped = fields.folded, fields.foldedSkipped
+		property = strings.ToLower(property)
+	}
+	if sf, ok := byName[property]; ok {
+		return fieldByIndex(v, sf.index, true), sf.opts, false
+	}
+	return reflect.Value{}, nil, skipped[property]
+}
+
+// structField is the index and tag options of a struct field
+type structField struct {
+	index []int
+	opts  tagOptions
+}
+
+// structFields maps property names to the fields of a struct type, built once
+// per type so decoding doesn't scan the struct for every property
+type structFields struct {
+	byName  map[string]structField
+	skipped map[string]bool
+	// folded and foldedSkipped are keyed by the lowercase property name
+	folded        map[string]structField
+	foldedSkipped map[string]bool
+	// rest is the index of the field tagged wmi:",rest", or nil
+	rest []int
+}
+
+// indexCache holds the structFields for each struct type
+var indexCache sync.Map
+
+// fieldsOf returns the structFields for the struct type
+func fieldsOf(t reflect.Type) *structFields {
+	if fields, ok := indexCache.Load(t); ok {
+		return fields.(*structFields)
+	}
+	fields := &structFields{
+		byName:        map[string]structField{},
+		skipped:       map[string]bool{},
+		folded:        map[string]structField{},
+		foldedSkipped: map[string]bool{},
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if isRest(t.Field(i)) {
+			fields.rest = []int{i}
+			break
+		}
+	}
+	for _, p := range structProperties(t) {
+		if p.skip {
+			fields.skipped[p.name] = true
+			fields.foldedSkipped[strings.ToLower(p.name)] = true
+			continue
+		}
+		fields.byName[p.name] = structField{index: p.field.Index, opts: p.opts}
+		// The first field for a property is used, as with FieldByName
+		if _, ok := fields.folded[strings.ToLower(p.name)]; !ok {
+			fields.folded[strings.ToLower(p.name)] = structField{index: p.field.Index, opts: p.opts}
+		}
+	}
+	indexCache.Store(t, fields)
+	return fields
+}
+
+// propertyField is a struct field and the WMI property it is decoded from.
+// The field's Index is the index sequence from the outer struct
+type propertyField struct {
+	name  string
+	field reflect.StructField
+	opts  tagOptions
+	// skip is true for a field excluded with wmi:"-", name is the field name
+	skip  bool
+	depth int
+}
+
+// structProperties returns the properties of the fields of the struct type in
+// field order, including the fields of embedded structs. As with promoted
+// fields in Go a field hides the fields with the same property in embedded
+// structs. Of the fields at the same depth with the same property a tagged
+// field is used, otherwise the first
+func structProperties(t reflect.Type) []propertyField {
+	all := collectProperties(t, nil, 0, map[reflect.Type]bool{})
+
+	best := map[string]propertyField{}
+	for _, p := range all {
+		if b, ok := best[p.name]; !ok || dominates(p, b) {
+			best[p.name] = p
+		}
+	}
+	properties := []propertyField{}
+	for _, p := range all {
+		if reflect.DeepEqual(best[p.name].field.Index, p.field.Index) {
+			properties = append(properties, p)
+		}
+	}
+	return properties
+}
+
+// dominates returns true if field p hides field b with the same property
+func dominates(p, b propertyField) bool {
+	if p.depth != b.depth {
+		return p.depth < b.depth
+	}
+	tagged := func(f propertyField) bool {
+		name, _ := parseTag(f.field.Tag.Get("wmi"))
+		return name != "" && !f.skip
+	}
+	return tagged(p) && !tagged(b)
+}
+
+// collectProperties returns every candidate property of the struct type and
+// the structs embedded in it
+func collectProperties(t reflect.Type, index []int, depth int, visited map[reflect.Type]bool) []propertyField {
+	visited[t] = true
+	defer delete(visited, t)
+
+	properties := []propertyField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		sf.Index = append(append([]int{}, index...), i)
+		n, ok := propertyName(sf)
+		if !ok {
+			properties = append(properties, propertyField{name: sf.Name, field: sf, skip: true, depth: depth})
+			continue
+		}
+		if isRest(sf) {
+			continue
+		}
+		name, opts := parseTag(sf.Tag.Get("wmi"))
+		if ft := embeddedStruct(sf); ft != nil && name == "" {
+			// An unexported embedded pointer can't be allocated
+			if !visited[ft] && (sf.IsExported() || sf.Type.Kind() != reflect.Ptr) {
+				properties = append(properties, collectProperties(ft, sf.Index, depth+1, visited)...)
+			}
+			continue
+		}
+		properties = append(properties, propertyField{name: n, field: sf, opts: opts, depth: depth})
+	}
+	return properties
+}
+
+// isRest returns true for a field tagged wmi:",rest", which holds the
+// properties of a record that don't have a field of their own
+func isRest(sf reflect.StructField) bool {
+	name, opts := parseTag(sf.Tag.Get("wmi"))
+	return name == "" && opts.has("rest")
+}
+
+// embeddedStruct returns the struct type of an embedded struct or struct
+// pointer field whose fields are decoded as if they were in the outer struct,
+// or nil if the field isn't one
+func embeddedStruct(sf reflect.StructField) reflect.Type {
+	if !sf.Anonymous {
+		return nil
+	}
+	t := sf.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	return t
+}
+
+// fieldByIndex returns the nested field for the index sequence. Nil embedded
+// struct pointers are allocated if alloc is true, otherwise the zero value of
+// the field is returned
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Zero(fieldType(v.Type().Elem(), index[i:]))
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldType returns the type of the nested field for the index sequence
+func fieldType(t reflect.Type, index []int) reflect.Type {
+	for _, x := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		t = t.Field(x).Type
+	}
+	return t
+}
+
+// setSlice parses an array value in the form {"a","b"} into a slice field. A
+// value without braces is treated as a single element
+func setSlice(field, s string, v reflect.Value, opts tagOptions) error {
+	return fillSlice(field, splitArray(s), v, opts)
+}
+
+// setArray parses an array value in the form {"a","b"} into a fixed size
+// array field
+func setArray(field, s string, v reflect.Value, opts tagOptions) error {
+	return fillArray(field, splitArray(s), v, opts)
+}
+
+// isList returns true if the type, or the type a pointer is to, is a slice or
+// array that isn't a TextUnmarshaler
+func isList(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return false
+	}
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+
+// setElems sets a slice or array field, or a pointer to one, to the elements
+// of an array value
+func setElems(field string, elems []string, f reflect.Value, opts tagOptions) error {
+	if f.Kind() == reflect.Ptr {
+		p := reflect.New(f.Type().Elem())
+		err := setElems(field, elems, p.Elem(), opts)
+		if err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+	if f.Kind() == reflect.Array {
+		return fillArray(field, elems, f, opts)
+	}
+	return fillSlice(field, elems, f, opts)
+}
+
+// fillSlice sets a slice field to the elements
+func fillSlice(field string, elems []string, v reflect.Value, opts tagOptions) error {
+	slice := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+	for i, e := range elems {
+		err := setValue(field, e, slice.Index(i), opts)
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+// fillArray sets a fixed size array field to the elements. Elements beyond
+// the value are left zero, and an error is returned after filling the array
+// if there are more elements than fit
+func fillArray(field string, elems []string, v reflect.Value, opts tagOptions) error {
+	v.Set(reflect.Zero(v.Type()))
+	for i, e := range elems {
+		if i == v.Len() {
+			return fmt.Errorf("Unable to set field %s of type %s: %d elements is more than the array length", field, v.Type(), len(elems))
+		}
+		err := setValue(field, e, v.Index(i), opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitArray splits a WMIC array value into its elements, trimming the
+// surrounding braces and the quotes around each element. Only commas outside
+// quotes separate elements, so quoted elements can contain commas
+func splitArray(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+		if s == "" {
+			return []string{}
+		}
+	}
+	parts := []string{}
+	var part strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, unquoteElem(part.String()))
+			part.Reset()
+			continue
+		}
+		part.WriteRune(r)
+	}
+	return append(parts, unquoteElem(part.String()))
+}
+
+// unquoteElem trims the spaces and the quotes around an array element
+func unquoteElem(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 func setString(s string, v reflect.Value) error {
 	v.SetString(s)
 	return nil
 }
 
-func setIntN(s string, v reflect.Value, bits int) error {
-	n, err := strconv.ParseInt(s, 10, bits)
+func setIntN(field, s string, v reflect.Value, bits int, hex bool) error {
+	digits, base := intBase(s, hex)
+	n, err := strconv.ParseInt(digits, base, bits)
 	if err != nil {
-		return fmt.Errorf("Unable to set field %s type %s", v.Type().Name, s)
+		return parseError(field, s, v)
 	}
 	v.SetInt(n)
 	return nil
 }
 
-func setUintN(s string, v reflect.Value, bits int) error {
-	n, err := strconv.ParseUint(s, 10, bits)
+func setUintN(field, s string, v reflect.Value, bits int, hex bool) error {
+	if strings.HasPrefix(s, "-") {
+		return fmt.Errorf("Unable to set field %s of type %s to %q, the value is negative. Use WithWrapNegative for values printed as signed", field, v.Type(), s)
+	}
+	digits, base := intBase(s, hex)
+	n, err := strconv.ParseUint(digits, base, bits)
 	if err != nil {
-		return fmt.Errorf("Unable to set field %s type %s", v.Type().Name, s)
+		return parseError(field, s, v)
 	}
 	v.SetUint(n)
 	return nil
 }
 
-func setFloatN(s string, v reflect.Value, bits int) error {
+// setBigInt parses an integer of any size into a big.Int field, e.g. a raw
+// counter that overflows uint64
+func setBigInt(field, s string, v reflect.Value, hex bool) error {
+	digits, base := intBase(s, hex)
+	if _, ok := v.Addr().Interface().(*big.Int).SetString(digits, base); !ok {
+		return parseError(field, s, v)
+	}
+	return nil
+}
+
+// setBigFloat parses a number of any size into a big.Float field. A field
+// without a precision gets enough for every digit of the value
+func setBigFloat(field, s string, v reflect.Value) error {
+	f := v.Addr().Interface().(*big.Float)
+	if f.Prec() == 0 {
+		f.SetPrec(uint(len(s)) * 4)
+	}
+	if _, ok := f.SetString(s); !ok {
+		return parseError(field, s, v)
+	}
+	return nil
+}
+
+// isUint returns true for the unsigned integer kinds
+func isUint(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// elemType returns the type a pointer type points to, through any number of
+// pointers
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// wrapNegative reinterprets a negative integer as the unsigned integer with
+// the same bits, e.g. -1 is 4294967295 for 32 bits. Values that aren't
+// integers of that size are returned as is so they fail parsing
+func wrapNegative(s string, bits int, hex bool) string {
+	digits, base := intBase(s, hex)
+	n, err := strconv.ParseInt(digits, base, bits)
+	if err != nil {
+		return s
+	}
+	u := uint64(n)
+	if bits < 64 {
+		u &= 1<<uint(bits) - 1
+	}
+	return strconv.FormatUint(u, 10)
+}
+
+// parseError is the error for a value that can't be parsed into the field
+func parseError(field, s string, v reflect.Value) error {
+	return fmt.Errorf("Unable to set field %s of type %s to %q", field, v.Type(), s)
+}
+
+// setNumber keeps the raw value of a json.Number field, checking it is a
+// valid number so the field can be converted later
+func setNumber(field, s string, v reflect.Value) error {
+	if !isJSONNumber(s) {
+		return parseError(field, s, v)
+	}
+	v.SetString(s)
+	return nil
+}
+
+// intBase returns the digits and base of an integer value, which is hex if it
+// has a 0x prefix or the field is tagged hex
+func intBase(s string, hex bool) (string, int) {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return sign + s[2:], 16
+	}
+	if hex {
+		return sign + s, 16
+	}
+	return sign + s, 10
+}
+
+func setFloatN(field, s string, v reflect.Value, bits int) error {
 	n, err := strconv.ParseFloat(s, bits)
 	if err != nil {
-		return fmt.Errorf("Unable to set field %s type %s", v.Type().Name, s)
+		return parseError(field, s, v)
 	}
 	v.SetFloat(n)
 	return nil
 }
 
-func setBool(s string, v reflect.Value) error {
-	b, err := strconv.ParseBool(s)
+// numberKind returns the kind of a number or number pointer field, or
+// reflect.Invalid if the field isn't a number
+func numberKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return reflect.Invalid
+	}
+	switch t {
+	case numberType, bigFloatType:
+		return reflect.Float64
+	case bigIntType:
+		return reflect.Int64
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return t.Kind()
+	}
+	return reflect.Invalid
+}
+
+// normalizeFloat converts a float formatted with the decimal separator, and
+// possibly grouped, to the format strconv.ParseFloat accepts. With a comma
+// decimal separator the digits may be grouped with periods or spaces, e.g.
+// 1.234,56 or 1 234,56
+func normalizeFloat(s, decimal string) string {
+	if decimal == "." {
+		return s
+	}
+	s = strings.NewReplacer(".", "", " ", "", "\u00a0", "", "\u202f", "").Replace(s)
+	return strings.Replace(s, decimal, ".", -1)
+}
+
+// setBool parses booleans, wmic prints these as TRUE and FALSE
+func setBool(field, s string, v reflect.Value) error {
+	b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(s)))
 	if err != nil {
-		return fmt.Errorf("Unable to set field %s type %s", v.Type().Name, s)
+		return parseError(field, s, v)
 	}
 	v.SetBool(b)
 	return nil
 }
+
+// setTime parses a CIM_DATETIME value into a time.Time field, or with the Go
+// time layout in the datetime option, e.g. wmi:"InstallDate,datetime=20060102"
+func setTime(field, s string, v reflect.Value, opts tagOptions) error {
+	if layout, ok := opts.value("datetime"); ok {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return fmt.Errorf("Unable to set field %s to %q with layout %s", field, s, layout)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	t, err := parseDatetime(s)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// parseDatetime parses a CIM_DATETIME value in the form yyyymmddHHMMSS.mmmmmmsUUU
+// where sUUU is the offset from UTC in minutes
+func parseDatetime(s string) (time.Time, error) {
+	if len(s) != 25 || s[14] != '.' || (s[21] != '+' && s[21] != '-') {
+		return time.Time{}, fmt.Errorf("Unable to parse datetime %s", s)
+	}
+	offset, err := strconv.Atoi(s[22:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unable to parse datetime %s", s)
+	}
+	if s[21] == '-' {
+		offset = -offset
+	}
+	loc := time.FixedZone("", offset*60)
+	t, err := time.ParseInLocation("20060102150405.000000", s[:21], loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unable to parse datetime %s", s)
+	}
+	return t, nil
+}
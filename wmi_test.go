@@ -1,9 +1,18 @@
 package wmic
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/big"
 	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -89,7 +98,7 @@ func TestServiceAll(t *testing.T) {
 
 	out := []*win32Service{}
 	start := time.Now()
-	err := QueryAll("Win32_Processor", &out)
+	_, err := QueryAll("Win32_Processor", &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
@@ -101,7 +110,7 @@ func TestServiceColumns(t *testing.T) {
 
 	out := []*win32Service{}
 	start := time.Now()
-	err := QueryColumns("Win32_Service", []string{"Name", "DisplayName", "StartMode", "StartName", "PathName", "State"}, &out)
+	_, err := QueryColumns("Win32_Service", []string{"Name", "DisplayName", "StartMode", "StartName", "PathName", "State"}, &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
@@ -113,7 +122,7 @@ func TestServiceColumnsWhere(t *testing.T) {
 
 	out := []*win32Service{}
 	start := time.Now()
-	err := Query("Win32_Service", []string{"Name", "DisplayName", "StartMode", "StartName", "PathName", "State"}, "(PathName LIKE '%tm1sd%')", &out)
+	_, err := Query("Win32_Service", []string{"Name", "DisplayName", "StartMode", "StartName", "PathName", "State"}, "(PathName LIKE '%tm1sd%')", &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
@@ -125,7 +134,7 @@ func TestServiceWhere(t *testing.T) {
 
 	out := []*win32Service{}
 	start := time.Now()
-	err := QueryWhere("Win32_Service", "(PathName LIKE '%tm1sd%')", &out)
+	_, err := QueryWhere("Win32_Service", "(PathName LIKE '%tm1sd%')", &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
@@ -137,7 +146,7 @@ func TestProcess(t *testing.T) {
 
 	out := []*perfResult{}
 	start := time.Now()
-	err := QueryColumns("Win32_PerfFormattedData_PerfProc_Process", []string{"IDProcess", "ElapsedTime", "PercentProcessorTime", "ThreadCount", "WorkingSet"}, &out)
+	_, err := QueryColumns("Win32_PerfFormattedData_PerfProc_Process", []string{"IDProcess", "ElapsedTime", "PercentProcessorTime", "ThreadCount", "WorkingSet"}, &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
@@ -149,10 +158,1190 @@ func TestProcessWhere(t *testing.T) {
 
 	out := []*perfResult{}
 	start := time.Now()
-	err := Query("Win32_PerfFormattedData_PerfProc_Process", []string{"IDProcess", "ElapsedTime", "PercentProcessorTime", "ThreadCount", "WorkingSet"}, "(IDProcess=15276 or IDProcess=1068 or IDProcess=4640)", &out)
+	_, err := Query("Win32_PerfFormattedData_PerfProc_Process", []string{"IDProcess", "ElapsedTime", "PercentProcessorTime", "ThreadCount", "WorkingSet"}, "(IDProcess=15276 or IDProcess=1068 or IDProcess=4640)", &out)
 	if err != nil {
 		log.Fatalf("wmi query failed: %s", err)
 	}
 	fmt.Printf("%s:%v", time.Since(start), len(out))
 
 }
+
+func TestBuildArgsColumns(t *testing.T) {
+
+	args := buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), &QueryOptions{Columns: []string{"Name", "State"}})
+	got := strings.Join(args, " ")
+	if !strings.Contains(got, "GET Name,State ") {
+		t.Fatalf("expected only the requested columns, got %s", got)
+	}
+	if strings.Contains(got, "DisplayName") {
+		t.Fatalf("unexpected struct column in %s", got)
+	}
+
+}
+
+func TestGetListCache(t *testing.T) {
+
+	lists := []string{}
+	{
+		type item struct{ Name, State string }
+		lists = append(lists, getList(reflect.TypeOf(item{}), nil))
+	}
+	{
+		type item struct{ ProcessId int }
+		lists = append(lists, getList(reflect.TypeOf(item{}), nil))
+		lists = append(lists, getList(reflect.TypeOf(item{}), []string{"Name"}))
+		lists = append(lists, getList(reflect.TypeOf(item{}), nil))
+	}
+	if strings.Join(lists, " ") != "Name,State ProcessId Name ProcessId" {
+		t.Fatalf("expected a get list per struct type, got %v", lists)
+	}
+
+}
+
+func TestBuildArgsOutputFormat(t *testing.T) {
+
+	args := buildArgs("Win32_Service", reflect.TypeOf(win32Service{}), &QueryOptions{})
+	formats := []string{}
+	for _, a := range args {
+		u := strings.ToUpper(a)
+		if u == "/VALUE" || strings.HasPrefix(u, "/FORMAT") {
+			formats = append(formats, a)
+		}
+	}
+	if len(formats) != 1 || formats[0] != "/VALUE" || args[len(args)-1] != "/VALUE" {
+		t.Fatalf("expected exactly one output format, got %v", args)
+	}
+
+}
+
+func TestDecodeLongLine(t *testing.T) {
+
+	long := strings.Repeat("x", 100*1024)
+	data := "\nName=" + long + "\nState=Running\n\n"
+	out := []win32Service{}
+	_, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].Name != long || out[0].State != "Running" {
+		t.Fatalf("long value not parsed intact")
+	}
+
+}
+
+func TestDecodeCRLFAndBOM(t *testing.T) {
+
+	data := "\ufeff\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\nName=W32Time\r\r\nState=Stopped\r\r\n\r\r\n"
+	out := []win32Service{}
+	_, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %v", len(out))
+	}
+	if out[0].Name != "Spooler" || out[0].State != "Running" || out[1].Name != "W32Time" || out[1].State != "Stopped" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+}
+
+func TestDecodeUTF16(t *testing.T) {
+
+	data := []byte{0xFF, 0xFE}
+	for _, r := range "Name=Spooler\r\r\n\r\r\n" {
+		data = append(data, byte(r), 0)
+	}
+	out := []win32Service{}
+	_, err := decode(bytes.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].Name != "Spooler" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+}
+
+func TestNoInstances(t *testing.T) {
+
+	for _, s := range []string{"No Instance(s) Available.", "no instance(s) available. \r\n", "\r\nNO INSTANCE(S) AVAILABLE.\n\n"} {
+		if !noInstances([]byte(s)) {
+			t.Errorf("expected %q to match", s)
+		}
+	}
+	if noInstances([]byte("Name=No Instance(s) Available.")) {
+		t.Errorf("unexpected match for property value")
+	}
+
+}
+
+func TestSetBool(t *testing.T) {
+
+	tests := map[string]bool{"TRUE": true, "FALSE": false, "1": true, "0": false, "True": true}
+	for s, want := range tests {
+		var b bool
+		err := setBool("Started", s, reflect.ValueOf(&b).Elem())
+		if err != nil || b != want {
+			t.Errorf("setBool(%s) = %v %v, want %v", s, b, err, want)
+		}
+	}
+	var b bool
+	if err := setBool("Started", "YES", reflect.ValueOf(&b).Elem()); err == nil {
+		t.Errorf("expected an error for YES")
+	}
+
+}
+
+type hexResult struct {
+	Code    int32
+	Address uint64 `wmi:"Address,hex"`
+}
+
+func TestDecodeHex(t *testing.T) {
+
+	data := "Code=0x1F\nAddress=FF00\n\nCode=-0X10\nAddress=0x10\n\nCode=42\nAddress=10\n\n"
+	out := []hexResult{}
+	errs, err := decode(strings.NewReader(data), "Test", &out, &QueryOptions{})
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	want := []hexResult{{31, 0xFF00}, {-16, 16}, {42, 16}}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %+v, want %+v", out, want)
+	}
+
+}
+
+// serviceState parses itself from the wmic State value
+type serviceState int
+
+const (
+	stateUnknown serviceState = iota
+	stateRunning
+	stateStopped
+)
+
+func (s *serviceState) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "Running":
+		*s = stateRunning
+	case "Stopped":
+		*s = stateStopped
+	default:
+		return fmt.Errorf("Unknown state %s", text)
+	}
+	return nil
+}
+
+type stateResult struct {
+	Name      string
+	State     serviceState
+	LastState *serviceState
+}
+
+func TestDecodeTextUnmarshaler(t *testing.T) {
+
+	data := "Name=Spooler\nState=Running\nLastState=Stopped\n\nName=W32Time\nState=Paused\n\n"
+	out := []stateResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].State != stateRunning || out[0].LastState == nil || *out[0].LastState != stateStopped {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(errs) != 1 || errs[0].Field != "State" || errs[0].Record != 2 || errs[0].Line != 6 {
+		t.Fatalf("expected a record error for the unknown state, got %+v", errs)
+	}
+
+}
+
+func TestRecordErrorLine(t *testing.T) {
+
+	data := "\r\r\n\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\n\r\r\n\r\r\nName=W32Time\r\r\nState=Paused\r\r\n\r\r\nName=Dhcp\r\r\nState=Unknown\r\r\n\r\r\n"
+	out := []stateResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 records, got %+v", out)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 record errors, got %+v", errs)
+	}
+	if errs[0].Record != 2 || errs[0].Line != 9 {
+		t.Fatalf("expected the error on record 2 line 9, got %+v", errs[0])
+	}
+	if errs[1].Record != 3 || errs[1].Line != 12 {
+		t.Fatalf("expected the error on record 3 line 12, got %+v", errs[1])
+	}
+
+}
+
+// diskUsage computes the used space from two properties
+type diskUsage struct {
+	DeviceID  string
+	Size      uint64
+	FreeSpace uint64
+	Used      uint64 `wmi:"-"`
+}
+
+func (d *diskUsage) UnmarshalWMI(fields map[string]string) error {
+	d.DeviceID = fields["DeviceID"]
+	var err error
+	d.Size, err = strconv.ParseUint(fields["Size"], 10, 64)
+	if err != nil {
+		return err
+	}
+	d.FreeSpace, err = strconv.ParseUint(fields["FreeSpace"], 10, 64)
+	if err != nil {
+		return err
+	}
+	d.Used = d.Size - d.FreeSpace
+	return nil
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+
+	data := "DeviceID=C:\nFreeSpace=40\nSize=100\nVolumeName=OS\n\nDeviceID=D:\nFreeSpace=\nSize=\n\n"
+	out := []*diskUsage{}
+	errs, err := decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Used != 60 || out[1].DeviceID != "D:" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(errs) != 1 || errs[0].Record != 2 || errs[0].Line != 6 {
+		t.Fatalf("expected a record error for the empty size, got %+v", errs)
+	}
+
+}
+
+type blankResult struct {
+	Name        string
+	Description *string
+	Caption     *string
+	ProcessId   int
+	Priority    *int
+	Started     bool
+}
+
+func TestDecodeBlankValues(t *testing.T) {
+
+	data := "Name=\nDescription=\nProcessId=\nPriority=\nStarted=\n\n"
+	out := []blankResult{{Name: "stale"}}
+	errs, err := decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{})
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	r := out[0]
+	if r.Name != "" || r.Description == nil || *r.Description != "" {
+		t.Fatalf("expected blank strings to be assigned, got %+v", r)
+	}
+	if r.Caption != nil || r.Priority != nil || r.ProcessId != 0 || r.Started {
+		t.Fatalf("expected absent and blank non-string values to be unset, got %+v", r)
+	}
+
+}
+
+type lenientResult struct {
+	Name     string
+	Services map[string]string
+	State    int
+}
+
+func TestDecodeLenient(t *testing.T) {
+
+	data := "Name=Spooler\nServices=x\nState=Running\nStatus=OK\n\nName=W32Time\n\n"
+	out := []lenientResult{}
+	_, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if _, ok := err.(*UnsupportedTypeError); !ok {
+		t.Fatalf("expected an UnsupportedTypeError in strict mode, got %v", err)
+	}
+
+	errs, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Name != "Spooler" || out[1].Name != "W32Time" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	if strings.Join(fields, ",") != "Services,State,Status" {
+		t.Fatalf("expected record errors for Services, State and Status, got %+v", errs)
+	}
+
+}
+
+type processResult struct {
+	CommandLine string
+	Name        string
+	ProcessId   int
+}
+
+func TestDecodeMultiLine(t *testing.T) {
+
+	data := "CommandLine=\"C:\\app.exe\" --first\r\r\n--config=c:\\app.ini\r\r\nName=app.exe\r\r\nProcessId=42\r\r\n\r\r\nCommandLine=x\r\r\nName=b\r\r\nProcessId=7\r\r\n\r\r\n"
+	out := []processResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{})
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	want := []processResult{
+		{CommandLine: "\"C:\\app.exe\" --first\n--config=c:\\app.ini", Name: "app.exe", ProcessId: 42},
+		{CommandLine: "x", Name: "b", ProcessId: 7},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %+v, want %+v", out, want)
+	}
+
+}
+
+func TestDecodeValue(t *testing.T) {
+
+	out := []*win32Service{}
+	_, err := DecodeValue([]byte(serviceOutput), &out)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Name != "Spooler" || out[1].State != "Stopped" {
+		t.Fatalf("unexpected services %v", out)
+	}
+
+	data := "Name=Spooler\r\r\nUnknown=1\r\r\n\r\r\n"
+	errs, err := DecodeValueReader(strings.NewReader(data), &out, WithLenient())
+	if err != nil {
+		t.Fatalf("lenient decode failed: %s", err)
+	}
+	if len(out) != 1 || len(errs) != 1 || errs[0].Class != "win32Service" {
+		t.Fatalf("expected one record error, got %v", errs)
+	}
+
+	var single win32Service
+	_, err = DecodeValue([]byte(serviceOutput), &single)
+	if err == nil {
+		t.Fatalf("expected an error decoding into a struct")
+	}
+
+}
+
+func TestDecodeIgnoreCase(t *testing.T) {
+
+	type process struct {
+		Name      string
+		ProcessID int
+		Parent    int `wmi:"parentprocessid"`
+	}
+	data := "\r\r\nName=svchost.exe\r\r\nParentProcessId=612\r\r\nProcessId=1044\r\r\n\r\r\n"
+	out := []process{}
+	_, err := decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{})
+	if _, ok := err.(*FieldError); !ok {
+		t.Fatalf("expected a FieldError by default, got %v", err)
+	}
+
+	_, err = decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].ProcessID != 1044 || out[0].Parent != 612 {
+		t.Fatalf("unexpected processes %v", out)
+	}
+
+	args := buildArgs("Win32_Process", reflect.TypeOf(process{}), &QueryOptions{IgnoreCase: true})
+	if !strings.Contains(strings.Join(args, " "), "GET Name,ProcessID,parentprocessid ") {
+		t.Fatalf("expected the field names in the GET list, got %v", args)
+	}
+
+}
+
+func BenchmarkDecode(b *testing.B) {
+
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "\r\r\nDisplayName=Service %d\r\r\nName=svc%d\r\r\nPathName=C:\\\\Windows\\\\svc%d.exe\r\r\nStartMode=Auto\r\r\nStartName=LocalSystem\r\r\nState=Running\r\r\n\r\r\n", i, i, i)
+	}
+	data := buf.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := []win32Service{}
+		_, err := DecodeValue(data, &out)
+		if err != nil || len(out) != 10000 {
+			b.Fatalf("decode failed: %v", err)
+		}
+	}
+
+}
+
+func TestDecodeEnum(t *testing.T) {
+
+	type logicalDisk struct {
+		DeviceID  string
+		DriveType string  `wmi:"DriveType,enum=removable:2;fixed:3;network:4"`
+		Access    *string `wmi:"Access,enum=read:1;write:2;readwrite:3,strict"`
+	}
+	data := "\r\r\nAccess=3\r\r\nDeviceID=C:\r\r\nDriveType=3\r\r\n\r\r\n\r\r\nAccess=0\r\r\nDeviceID=Z:\r\r\nDriveType=6\r\r\n\r\r\n"
+	out := []logicalDisk{}
+	errs, err := decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].DriveType != "fixed" || out[0].Access == nil || *out[0].Access != "readwrite" {
+		t.Fatalf("expected the enum names, got %+v", out[0])
+	}
+	if out[1].DriveType != "6" || out[1].Access != nil {
+		t.Fatalf("expected the raw unmapped value, got %+v", out[1])
+	}
+	if len(errs) != 1 || errs[0].Field != "Access" || errs[0].Record != 2 || errs[0].Line != 7 {
+		t.Fatalf("expected a record error for the strict enum, got %v", errs)
+	}
+
+}
+
+func TestDecodeDecimalSeparator(t *testing.T) {
+
+	type counter struct {
+		Name  string
+		Value float64
+		Max   *float64
+		Min   float32
+	}
+	data := "\r\r\nMax=1 234 567,5\r\r\nMin=3,14\r\r\nName=cpu\r\r\nValue=1.234,56\r\r\n\r\r\n"
+	out := []counter{}
+	errs, err := decode(strings.NewReader(data), "Win32_PerfFormattedData", &out, &QueryOptions{})
+	if err != nil || len(errs) != 3 {
+		t.Fatalf("expected comma decimals to fail by default, got %v %v", err, errs)
+	}
+
+	errs, err = decode(strings.NewReader(data), "Win32_PerfFormattedData", &out, &QueryOptions{DecimalSeparator: ","})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	if len(out) != 1 || out[0].Value != 1234.56 || out[0].Max == nil || *out[0].Max != 1234567.5 || out[0].Min != 3.14 {
+		t.Fatalf("unexpected values %+v", out)
+	}
+	if out[0].Name != "cpu" {
+		t.Fatalf("expected strings to be unchanged, got %s", out[0].Name)
+	}
+
+}
+
+func TestDecodeGroupSeparator(t *testing.T) {
+
+	type logicalDisk struct {
+		DeviceID   string
+		VolumeName string
+		FreeSpace  uint64
+		Size       *int64
+	}
+	data := "\r\r\nDeviceID=C:\r\r\nFreeSpace=1,048,576\r\r\nSize=2,097,152\r\r\nVolumeName=Data, Backups\r\r\n\r\r\n\r\r\nDeviceID=D:\r\r\nFreeSpace=4096\r\r\nSize=8192\r\r\nVolumeName=\r\r\n\r\r\n"
+	out := []logicalDisk{}
+	errs, err := decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{})
+	if err != nil || len(errs) != 2 {
+		t.Fatalf("expected grouped numbers to fail by default, got %v %v", err, errs)
+	}
+
+	errs, err = decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{GroupSeparator: ","})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("decode failed: %v %v", err, errs)
+	}
+	if len(out) != 2 || out[0].FreeSpace != 1048576 || out[0].Size == nil || *out[0].Size != 2097152 {
+		t.Fatalf("unexpected grouped values %+v", out[0])
+	}
+	if out[1].FreeSpace != 4096 || *out[1].Size != 8192 {
+		t.Fatalf("unexpected ungrouped values %+v", out[1])
+	}
+	if out[0].VolumeName != "Data, Backups" {
+		t.Fatalf("expected strings to keep the separator, got %s", out[0].VolumeName)
+	}
+
+	errs, err = decode(strings.NewReader("\r\r\nValue=1.234.567,5\r\r\n\r\r\n"), "Test", &[]struct{ Value float64 }{}, &QueryOptions{GroupSeparator: ".", DecimalSeparator: ","})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("expected grouped floats to parse, got %v %v", err, errs)
+	}
+
+}
+
+func TestParseErrorMessages(t *testing.T) {
+
+	type process struct {
+		ProcessId  int32
+		WorkingSet uint64
+		Priority   float32
+		Started    bool
+	}
+	data := "\r\r\nPriority=high\r\r\nProcessId=0x\r\r\nStarted=YES\r\r\nWorkingSet=-1\r\r\n\r\r\n"
+	out := []process{}
+	errs, err := decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{})
+	if err != nil || len(errs) != 4 {
+		t.Fatalf("expected 4 record errors, got %v %v", err, errs)
+	}
+	expected := []string{
+		`Unable to set field Priority of type float32 to "high"`,
+		`Unable to set field ProcessId of type int32 to "0x"`,
+		`Unable to set field Started of type bool to "YES"`,
+		`Unable to set field WorkingSet of type uint64 to "-1", the value is negative. Use WithWrapNegative for values printed as signed`,
+	}
+	values := []string{"high", "0x", "YES", "-1"}
+	for i, e := range errs {
+		if e.Message != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], e.Message)
+		}
+		if e.Value != values[i] {
+			t.Errorf("expected the raw value %s, got %s", values[i], e.Value)
+		}
+	}
+
+}
+
+type pointerProcess struct {
+	Name           *string
+	ProcessId      *uint32
+	CommandLine    *string
+	CreationDate   *time.Time
+	ThreadCount    int
+	Node           string `wmi:"__node"`
+	ExecutablePath string
+}
+
+func TestDecodePointerSlice(t *testing.T) {
+
+	data := "\r\r\nCommandLine=\r\r\nCreationDate=20231105143000.000000+060\r\r\nExecutablePath=C:\\Windows\\System32\\svchost.exe\r\r\nName=svchost.exe\r\r\nProcessId=1068\r\r\nThreadCount=12\r\r\n\r\r\n" +
+		"\r\r\nCommandLine=\r\r\nCreationDate=\r\r\nExecutablePath=\r\r\nName=System\r\r\nProcessId=4\r\r\nThreadCount=150\r\r\n\r\r\n"
+	values := []pointerProcess{}
+	pointers := []*pointerProcess{}
+	for _, out := range []interface{}{&values, &pointers} {
+		_, err := QueryWith("Win32_Process", out, WithRunner(&fakeRunner{stdout: data}), WithNode("SERVER1"), WithOrderBy("ProcessId"))
+		if err != nil {
+			t.Fatalf("query failed: %s", err)
+		}
+	}
+	if len(pointers) != 2 || pointers[0] == nil || pointers[1] == nil {
+		t.Fatalf("expected 2 allocated records, got %v", pointers)
+	}
+	for i := range pointers {
+		if !reflect.DeepEqual(*pointers[i], values[i]) {
+			t.Fatalf("expected the same records for []T and []*T, got %+v and %+v", *pointers[i], values[i])
+		}
+	}
+	p := pointers[1]
+	if *p.Name != "svchost.exe" || *p.ProcessId != 1068 || p.CommandLine == nil || *p.CommandLine != "" || p.CreationDate.Hour() != 14 || p.ThreadCount != 12 || p.Node != "SERVER1" {
+		t.Fatalf("unexpected record %+v", p)
+	}
+	if pointers[0].CreationDate != nil || *pointers[0].ProcessId != 4 {
+		t.Fatalf("expected the blank date to be nil and the records sorted, got %+v", pointers[0])
+	}
+	if pointers[0] == pointers[1] || pointers[0].Name == pointers[1].Name {
+		t.Fatalf("expected each record and field to be allocated separately")
+	}
+
+}
+
+type managedElement struct {
+	Caption     string
+	Description string
+	Name        string
+}
+
+// ManagedElement is exported so it can be allocated when embedded as a pointer
+type ManagedElement struct {
+	Caption string
+}
+
+type logicalElement struct {
+	*ManagedElement
+	Status string
+}
+
+type embeddedService struct {
+	managedElement
+	Name  string `wmi:"Name"`
+	State string
+}
+
+func TestDecodeEmbedded(t *testing.T) {
+
+	data := "\r\r\nCaption=Print Spooler\r\r\nDescription=Queues print jobs\r\r\nName=Spooler\r\r\nState=Running\r\r\n\r\r\n"
+	out := []embeddedService{}
+	f := &fakeRunner{stdout: data}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if got := strings.Join(f.args[0], " "); got != "PATH Win32_Service GET Caption,Description,Name,State /VALUE" {
+		t.Fatalf("expected the embedded fields in the GET list, got %s", got)
+	}
+	if len(out) != 1 || out[0].Caption != "Print Spooler" || out[0].Description != "Queues print jobs" || out[0].State != "Running" {
+		t.Fatalf("unexpected record %+v", out)
+	}
+	if out[0].Name != "Spooler" || out[0].managedElement.Name != "" {
+		t.Fatalf("expected the outer Name field to be set, got %+v", out[0])
+	}
+
+	pointers := []logicalElement{}
+	_, err = decode(strings.NewReader("\r\r\nCaption=C:\r\r\nStatus=OK\r\r\n\r\r\n\r\r\nStatus=Degraded\r\r\n\r\r\n"), "CIM_LogicalElement", &pointers, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(pointers) != 2 || pointers[0].ManagedElement == nil || pointers[0].Caption != "C:" || pointers[1].ManagedElement != nil {
+		t.Fatalf("expected the embedded pointer to be allocated when set, got %+v", pointers)
+	}
+	err = sortRecords(reflect.ValueOf(&pointers).Elem(), []string{"Caption DESC"})
+	if err != nil || pointers[0].Caption != "C:" {
+		t.Fatalf("expected sorting by an embedded field, got %v %v", pointers, err)
+	}
+
+}
+
+type networkAdapter struct {
+	Name       string
+	MACAddress [6]byte `wmi:"MACAddress,hex"`
+	IPAddress  [4]uint8
+}
+
+func TestDecodeArray(t *testing.T) {
+
+	data := "\r\r\nIPAddress={\"10\",\"0\",\"0\"}\r\r\nMACAddress={\"00\",\"1A\",\"2B\",\"3C\",\"4D\",\"5E\"}\r\r\nName=Ethernet\r\r\n\r\r\n" +
+		"\r\r\nIPAddress={\"192\",\"168\",\"1\",\"10\",\"5\"}\r\r\nMACAddress={}\r\r\nName=Wi-Fi\r\r\n\r\r\n"
+	out := []networkAdapter{}
+	recordErrors, err := decode(strings.NewReader(data), "Win32_NetworkAdapter", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	if out[0].MACAddress != [6]byte{0x00, 0x1A, 0x2B, 0x3C, 0x4D, 0x5E} {
+		t.Fatalf("unexpected MAC address %X", out[0].MACAddress)
+	}
+	if out[0].IPAddress != [4]uint8{10, 0, 0, 0} {
+		t.Fatalf("expected the missing elements to be zero, got %v", out[0].IPAddress)
+	}
+	if out[1].MACAddress != [6]byte{} || out[1].IPAddress != [4]uint8{192, 168, 1, 10} || out[1].Name != "Wi-Fi" {
+		t.Fatalf("expected the array to be filled to its length, got %+v", out[1])
+	}
+	if len(recordErrors) != 1 || recordErrors[0].Field != "IPAddress" {
+		t.Fatalf("expected an error for the extra element, got %v", recordErrors)
+	}
+
+}
+
+type quickFixEngineering struct {
+	HotFixID    string
+	InstalledOn time.Time  `wmi:"InstalledOn,datetime=1/2/2006"`
+	InstallDate *time.Time `wmi:"InstallDate,datetime=20060102"`
+}
+
+func TestDecodeDatetimeLayout(t *testing.T) {
+
+	data := "\r\r\nHotFixID=KB5034441\r\r\nInstallDate=20240109\r\r\nInstalledOn=1/9/2024\r\r\n\r\r\n" +
+		"\r\r\nHotFixID=KB5034439\r\r\nInstallDate=20240109000000.000000+000\r\r\nInstalledOn=1/10/2024\r\r\n\r\r\n"
+	out := []quickFixEngineering{}
+	recordErrors, err := decode(strings.NewReader(data), "Win32_QuickFixEngineering", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	want := time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)
+	if len(out) != 2 || !out[0].InstalledOn.Equal(want) || out[0].InstallDate == nil || !out[0].InstallDate.Equal(want) {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if !out[1].InstalledOn.Equal(want.AddDate(0, 0, 1)) || out[1].InstallDate != nil {
+		t.Fatalf("expected only the layout to be used, got %+v", out[1])
+	}
+	if len(recordErrors) != 1 || recordErrors[0].Field != "InstallDate" || !strings.Contains(recordErrors[0].Message, "20060102") {
+		t.Fatalf("expected an error for the mismatched layout, got %v", recordErrors)
+	}
+
+}
+
+type restService struct {
+	Name   string
+	State  string            `wmi:"State"`
+	Status string            `wmi:"-"`
+	Other  map[string]string `wmi:",rest"`
+}
+
+func TestDecodeRest(t *testing.T) {
+
+	data := "\r\r\nDisplayName=Print Spooler\r\r\nName=Spooler\r\r\nStartMode=Auto\r\r\nState=Running\r\r\nStatus=OK\r\r\n\r\r\n" +
+		"\r\r\nName=W32Time\r\r\nState=Stopped\r\r\n\r\r\n"
+	out := []restService{}
+	f := &fakeRunner{stdout: data}
+	_, err := QueryWith("Win32_Service", &out, WithRunner(f), WithAllColumns())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Name != "Spooler" || out[0].State != "Running" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if len(out[0].Other) != 2 || out[0].Other["DisplayName"] != "Print Spooler" || out[0].Other["StartMode"] != "Auto" {
+		t.Fatalf("expected only the unmatched properties in the rest map, got %v", out[0].Other)
+	}
+	if out[1].Other != nil {
+		t.Fatalf("expected no rest map without unmatched properties, got %v", out[1].Other)
+	}
+
+	_, err = QueryWith("Win32_Service", &out, WithRunner(f))
+	if got := strings.Join(f.args[1], " "); got != "PATH Win32_Service GET Name,State /VALUE" {
+		t.Fatalf("expected the rest field not to be queried, got %s", got)
+	}
+
+	bad := []struct {
+		Name  string
+		Other map[string]int `wmi:",rest"`
+	}{}
+	_, err = decode(strings.NewReader(data), "Win32_Service", &bad, &QueryOptions{})
+	if _, ok := err.(*UnsupportedTypeError); !ok {
+		t.Fatalf("expected an UnsupportedTypeError for the rest map type, got %v", err)
+	}
+
+}
+
+func TestSplitArray(t *testing.T) {
+
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{`{"a","b"}`, []string{"a", "b"}},
+		{`{"C:\Program Files\a, b","C:\Windows"}`, []string{`C:\Program Files\a, b`, `C:\Windows`}},
+		{`{ "x , y" , "z" }`, []string{"x , y", "z"}},
+		{`{"  spaced  ",plain}`, []string{"  spaced  ", "plain"}},
+		{`{1,2,3}`, []string{"1", "2", "3"}},
+		{`{""}`, []string{""}},
+		{`{}`, []string{}},
+		{`single`, []string{"single"}},
+	}
+	for _, test := range tests {
+		if got := splitArray(test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArray(%s) = %q, want %q", test.s, got, test.want)
+		}
+	}
+
+}
+
+type counterResult struct {
+	Name        string
+	Timestamp   json.Number
+	Frequency   *json.Number
+	PercentIdle json.Number
+}
+
+func TestDecodeJSONNumber(t *testing.T) {
+
+	data := "Frequency=\nName=_Total\nPercentIdle=97,5\nTimestamp=18446744073709551616\n\nName=0\nTimestamp=12ab\n\n"
+	out := []counterResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_PerfRawData_PerfOS_Processor", &out, &QueryOptions{DecimalSeparator: ","})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Timestamp != "18446744073709551616" || out[0].Frequency != nil {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if f, err := out[0].PercentIdle.Float64(); err != nil || f != 97.5 {
+		t.Fatalf("expected 97.5, got %s", out[0].PercentIdle)
+	}
+	if len(errs) != 1 || errs[0].Field != "Timestamp" || errs[0].Record != 2 {
+		t.Fatalf("expected a record error for the invalid number, got %+v", errs)
+	}
+
+}
+
+type rawCounterResult struct {
+	Name      string
+	Timestamp *big.Int
+	Counter   big.Int `wmi:"Counter,hex"`
+	Rate      *big.Float
+}
+
+func TestDecodeBig(t *testing.T) {
+
+	data := "Counter=1ffffffffffffffff\nName=_Total\nRate=18446744073709551616.5\nTimestamp=36893488147419103231\n\nName=Idle\nRate=\nTimestamp=abc\n\n"
+	out := []rawCounterResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_PerfRawData_PerfOS_Processor", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	max := new(big.Int).SetUint64(math.MaxUint64)
+	if out[0].Timestamp == nil || out[0].Timestamp.Cmp(max) <= 0 || out[0].Timestamp.String() != "36893488147419103231" {
+		t.Fatalf("expected a timestamp larger than MaxUint64, got %v", out[0].Timestamp)
+	}
+	if out[0].Counter.Text(16) != "1ffffffffffffffff" {
+		t.Fatalf("expected the hex counter, got %s", out[0].Counter.Text(16))
+	}
+	if out[0].Rate == nil || out[0].Rate.Text('f', 1) != "18446744073709551616.5" {
+		t.Fatalf("expected the exact rate, got %v", out[0].Rate)
+	}
+	if out[1].Timestamp != nil || out[1].Rate != nil {
+		t.Fatalf("expected unset fields, got %+v", out[1])
+	}
+	if len(errs) != 1 || errs[0].Field != "Timestamp" || errs[0].Record != 2 {
+		t.Fatalf("expected a record error for the invalid timestamp, got %+v", errs)
+	}
+
+}
+
+func TestDecodePaddedNames(t *testing.T) {
+
+	data := "Name =Spooler\r\r\nDisplayName\u00a0= Print Spooler\r\r\n\u200bState\t=Running\r\r\nStartMode\u202f\u00a0=Auto\r\r\n\r\r\n"
+	out := []win32Service{}
+	errs, err := decode(strings.NewReader(data), "Win32_Service", &out, &QueryOptions{})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("decode failed: %v %+v", err, errs)
+	}
+	if len(out) != 1 || out[0].Name != "Spooler" || out[0].DisplayName != "Print Spooler" || out[0].State != "Running" || out[0].StartMode != "Auto" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+}
+
+type annotatedResult struct {
+	Status   int     `wmi:"Status,number"`
+	Size     uint64  `wmi:"Size,number"`
+	Load     float64 `wmi:"Load,number"`
+	Address  uint32  `wmi:"Address,number,hex"`
+	Priority int
+}
+
+func TestDecodeLeadingNumber(t *testing.T) {
+
+	data := "Address=0x1F (mapped)\nLoad=12,5% used\nPriority=8\nSize=1 024 KB\nStatus=5 (running)\n\n" +
+		"Address=ff\nLoad=3\nPriority=9 (high)\nSize=2048\nStatus=-2\n\n" +
+		"Address=\nLoad=\nSize=KB\nStatus=(none)\n\n"
+	out := []annotatedResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_Test", &out, &QueryOptions{DecimalSeparator: ",", GroupSeparator: " "})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	want := []annotatedResult{
+		{Status: 5, Size: 1024, Load: 12.5, Address: 0x1f, Priority: 8},
+		{Status: -2, Size: 2048, Load: 3, Address: 0xff},
+		{},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %+v, want %+v", out, want)
+	}
+	// Only fields with the option have the annotation removed, values without
+	// a number are still errors
+	if len(errs) != 3 || errs[0].Field != "Priority" || errs[0].Record != 2 || errs[1].Field != "Size" || errs[2].Field != "Status" {
+		t.Fatalf("expected record errors for the annotated priority and values without a number, got %+v", errs)
+	}
+
+}
+
+type signedResult struct {
+	Name        string
+	ErrorCode   uint32
+	Mask        *uint16
+	Counter     uint64
+	Small       uint8
+	Unsupported uint8
+}
+
+func TestDecodeNegativeUint(t *testing.T) {
+
+	data := "Counter=-2\nErrorCode=-2147217406\nMask=-1\nName=a\nSmall=-128\nUnsupported=-129\n\n"
+
+	// Without the option negative values are errors and the fields unset
+	out := []signedResult{}
+	errs, err := decode(strings.NewReader(data), "Win32_Test", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].ErrorCode != 0 || out[0].Mask != nil {
+		t.Fatalf("expected unset fields, got %+v", out)
+	}
+	if len(errs) != 5 || errs[1].Field != "ErrorCode" || !strings.Contains(errs[1].Message, "negative") {
+		t.Fatalf("expected record errors for the negative values, got %+v", errs)
+	}
+
+	out = []signedResult{}
+	errs, err = decode(strings.NewReader(data), "Win32_Test", &out, &QueryOptions{WrapNegative: true})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].ErrorCode != 0x80041002 || out[0].Mask == nil || *out[0].Mask != math.MaxUint16 || out[0].Counter != math.MaxUint64-1 || out[0].Small != 128 {
+		t.Fatalf("expected the values reinterpreted, got %+v", out)
+	}
+	// -129 doesn't fit in 8 bits
+	if len(errs) != 1 || errs[0].Field != "Unsupported" {
+		t.Fatalf("expected a record error for the out of range value, got %+v", errs)
+	}
+
+}
+
+func TestRecordErrorValue(t *testing.T) {
+
+	type disk struct {
+		DeviceID  string
+		Size      uint64 `wmi:"Size,bytes"`
+		DriveType string `wmi:"DriveType,enum=fixed:3,strict"`
+	}
+	data := "DeviceID=C:\nDriveType=9\nSize=N/A\n\n"
+	out := []disk{}
+	errs, err := decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{GroupSeparator: "/"})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	// The value is as output, before the group separator is removed
+	if len(errs) != 2 || errs[0].Field != "DriveType" || errs[0].Value != "9" || errs[1].Field != "Size" || errs[1].Value != "N/A" {
+		t.Fatalf("expected record errors with the raw values, got %+v", errs)
+	}
+
+}
+
+func TestCheckClass(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	useRunner(t, f)
+	for _, class := range []string{"Win32_Service", "CPU", "__Namespace", `Win32_Service.Name="a b"`, "Win32_WmiSetting=@"} {
+		if err := checkClass(class); err != nil {
+			t.Errorf("unexpected error for %s: %s", class, err)
+		}
+	}
+
+	out := []win32Service{}
+	for _, class := range []string{"", "Win32 Service", "Win32_Service;calc", "9Class", `"Win32_Service"`, ".Name=1"} {
+		if _, err := QueryAll(class, &out); err == nil {
+			t.Errorf("expected an error for %q", class)
+		}
+	}
+	for _, columns := range [][]string{{"Name", "Display Name"}, {"Name", ""}, {""}} {
+		if _, err := QueryColumns("Win32_Service", columns, &out); err == nil || !strings.Contains(err.Error(), "Column") {
+			t.Errorf("expected a column error for %q, got %v", columns, err)
+		}
+	}
+	type badTag struct {
+		Name string `wmi:"Name)"`
+	}
+	if _, err := QueryAll("Win32_Service", &[]badTag{}); err == nil {
+		t.Error("expected an error for the invalid tag")
+	}
+	if _, err := QueryIter(context.Background(), "Win32 Service", nil, "", &win32Service{}); err == nil {
+		t.Error("expected QueryIter to check the class")
+	}
+	if _, _, err := CallMethod("Win32_Service", "", "Stop Service", nil); err == nil {
+		t.Error("expected CallMethod to check the method")
+	}
+	if err := SetProperty("Win32_Service", "", map[string]string{"Start Mode": "Auto"}); err == nil {
+		t.Error("expected SetProperty to check the properties")
+	}
+	if len(f.args) != 0 {
+		t.Fatalf("expected nothing to run, ran %q", f.args)
+	}
+
+}
+
+type taggedDisk struct {
+	Drive      string `wmi:"DeviceID"`
+	FreeSpace  uint64 `wmi:"FreeMegabytes"`
+	VolumeName string `wmi:""`
+	Checked    bool   `wmi:"-"`
+}
+
+func TestDecodeTags(t *testing.T) {
+
+	if list := getList(reflect.TypeOf(taggedDisk{}), nil); list != "DeviceID,FreeMegabytes,VolumeName" {
+		t.Fatalf("expected the tagged names without the skipped field, got %s", list)
+	}
+
+	data := "\r\r\nDeviceID=C:\r\r\nFreeMegabytes=20480\r\r\nVolumeName=System\r\r\n\r\r\n"
+	out := []taggedDisk{}
+	_, err := decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || out[0].Drive != "C:" || out[0].FreeSpace != 20480 || out[0].VolumeName != "System" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+	data = "\r\r\nChecked=TRUE\r\r\nDeviceID=D:\r\r\n\r\r\n"
+	_, err = decode(strings.NewReader(data), "Win32_LogicalDisk", &out, &QueryOptions{})
+	if err != nil || len(out) != 1 || out[0].Drive != "D:" || out[0].Checked {
+		t.Fatalf("expected the skipped field not to be set, got %+v %v", out, err)
+	}
+
+}
+
+func TestParseDatetime(t *testing.T) {
+
+	tests := []struct {
+		s      string
+		want   time.Time
+		offset int
+	}{
+		{"20231105143000.000000+000", time.Date(2023, 11, 5, 14, 30, 0, 0, time.UTC), 0},
+		{"20231105143000.123456+060", time.Date(2023, 11, 5, 13, 30, 0, 123456000, time.UTC), 3600},
+		{"20231105143000.000000-300", time.Date(2023, 11, 5, 19, 30, 0, 0, time.UTC), -18000},
+	}
+	for _, test := range tests {
+		got, err := parseDatetime(test.s)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("parseDatetime(%s) = %s, %v, want %s", test.s, got, err, test.want)
+			continue
+		}
+		if _, offset := got.Zone(); offset != test.offset {
+			t.Errorf("parseDatetime(%s) has offset %d, want %d", test.s, offset, test.offset)
+		}
+	}
+
+	for _, s := range []string{"", "2023-11-05", "20231105143000.000000*000", "20231305143000.000000+000", "20231105143000.000000+0x0"} {
+		if _, err := parseDatetime(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+
+}
+
+type bootRecord struct {
+	CSName         string
+	InstallDate    time.Time
+	LastBootUpTime time.Time
+}
+
+func TestDecodeDatetime(t *testing.T) {
+
+	data := "\r\r\nCSName=SERVER1\r\r\nInstallDate=\r\r\nLastBootUpTime=20231105143000.000000+000\r\r\n\r\r\n" +
+		"\r\r\nCSName=SERVER2\r\r\nInstallDate=yesterday\r\r\nLastBootUpTime=20231106080000.000000+000\r\r\n\r\r\n"
+	out := []bootRecord{}
+	recordErrors, err := decode(strings.NewReader(data), "Win32_OperatingSystem", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 || !out[0].InstallDate.IsZero() || out[0].LastBootUpTime.Day() != 5 {
+		t.Fatalf("unexpected records %+v", out)
+	}
+	if out[1].CSName != "SERVER2" || !out[1].InstallDate.IsZero() || out[1].LastBootUpTime.Hour() != 8 {
+		t.Fatalf("expected the rest of the record to be decoded, got %+v", out[1])
+	}
+	if len(recordErrors) != 1 || recordErrors[0].Field != "InstallDate" || recordErrors[0].Value != "yesterday" {
+		t.Fatalf("expected a RecordError for the malformed datetime, got %v", recordErrors)
+	}
+
+}
+
+type nullableProcess struct {
+	Name         *string
+	Priority     *int
+	Started      *bool
+	CreationDate *time.Time
+}
+
+func TestDecodePointers(t *testing.T) {
+
+	if list := getList(reflect.TypeOf(nullableProcess{}), nil); list != "Name,Priority,Started,CreationDate" {
+		t.Fatalf("expected the pointer fields in the GET list, got %s", list)
+	}
+
+	data := "\r\r\nCreationDate=20231105143000.000000+000\r\r\nName=svchost.exe\r\r\nPriority=0\r\r\nStarted=FALSE\r\r\n\r\r\n" +
+		"\r\r\nCreationDate=\r\r\nPriority=\r\r\nStarted=\r\r\n\r\r\n"
+	out := []nullableProcess{}
+	_, err := decode(strings.NewReader(data), "Win32_Process", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	p := out[0]
+	if p.Name == nil || *p.Name != "svchost.exe" || p.Priority == nil || *p.Priority != 0 || p.Started == nil || *p.Started || p.CreationDate == nil || p.CreationDate.Hour() != 14 {
+		t.Fatalf("expected present values to be assigned through the pointers, got %+v", p)
+	}
+	p = out[1]
+	if p.Name != nil || p.Priority != nil || p.Started != nil || p.CreationDate != nil {
+		t.Fatalf("expected absent values to leave the pointers nil, got %+v", p)
+	}
+
+}
+
+type adapterConfiguration struct {
+	Description       string
+	IPAddress         []string
+	IPSubnet          []string
+	DNSServerOrderID  []int `wmi:"DNSServerSearchOrder"`
+	GatewayCostMetric []uint16
+}
+
+func TestDecodeSlices(t *testing.T) {
+
+	data := "\r\r\nDescription=Ethernet\r\r\nDNSServerSearchOrder={\"1\",\"2\"}\r\r\nGatewayCostMetric={0,256}\r\r\nIPAddress={\"10.0.0.1\",\"10.0.0.2\"}\r\r\nIPSubnet=255.255.255.0\r\r\n\r\r\n" +
+		"\r\r\nDescription=Loopback\r\r\nDNSServerSearchOrder=\r\r\nGatewayCostMetric={}\r\r\nIPAddress={\"127.0.0.1\"}\r\r\nIPSubnet=\r\r\n\r\r\n"
+	out := []adapterConfiguration{}
+	_, err := decode(strings.NewReader(data), "Win32_NetworkAdapterConfiguration", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	a := out[0]
+	if !reflect.DeepEqual(a.IPAddress, []string{"10.0.0.1", "10.0.0.2"}) || !reflect.DeepEqual(a.DNSServerOrderID, []int{1, 2}) || !reflect.DeepEqual(a.GatewayCostMetric, []uint16{0, 256}) {
+		t.Fatalf("unexpected arrays %+v", a)
+	}
+	if !reflect.DeepEqual(a.IPSubnet, []string{"255.255.255.0"}) {
+		t.Fatalf("expected a scalar to be a one element slice, got %q", a.IPSubnet)
+	}
+	a = out[1]
+	if !reflect.DeepEqual(a.IPAddress, []string{"127.0.0.1"}) || a.IPSubnet != nil || a.DNSServerOrderID != nil || len(a.GatewayCostMetric) != 0 {
+		t.Fatalf("unexpected arrays %+v", a)
+	}
+
+}
+
+func TestQueryOneOut(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	useRunner(t, f)
+	for _, out := range []interface{}{nil, win32Service{}, &[]win32Service{}, new(string)} {
+		if err := QueryOne("Win32_Service", "", out); err == nil {
+			t.Errorf("expected an error for out %T", out)
+		}
+	}
+	if len(f.args) != 0 {
+		t.Fatalf("expected nothing to run for an invalid out, ran %q", f.args)
+	}
+	if errors.Is(ErrNoInstances, ErrMultipleInstances) || ErrNoInstances.Error() == ErrMultipleInstances.Error() {
+		t.Fatalf("expected distinct errors for zero and several instances")
+	}
+
+	out := win32Service{Name: "unchanged"}
+	if err := QueryOne("Win32_Service", "", &out); err != ErrMultipleInstances || out.Name != "unchanged" {
+		t.Fatalf("expected the struct to be left unchanged, got %+v %v", out, err)
+	}
+
+}
+
+func TestCountColumn(t *testing.T) {
+
+	tests := map[string]string{
+		"Win32_Process":     "Handle",
+		"WIN32_SERVICE":     "Name",
+		"win32_logicaldisk": "DeviceID",
+		"Win32_Environment": "__RELPATH",
+		"MSFT_NetAdapter":   "__RELPATH",
+	}
+	for class, want := range tests {
+		if got := countColumn(class); got != want {
+			t.Errorf("countColumn(%s) = %s, want %s", class, got, want)
+		}
+	}
+	for class := range keyProperties {
+		if class != strings.ToLower(class) {
+			t.Errorf("expected the class %s to be lower case", class)
+		}
+	}
+
+	args := buildArgs("Win32_Process", reflect.TypeOf(rawRecord{}), &QueryOptions{Columns: []string{countColumn("Win32_Process")}, Where: "Name='svchost.exe'"})
+	if got := strings.Join(args, " "); got != "PATH Win32_Process WHERE ( Name='svchost.exe' ) GET Handle /VALUE" {
+		t.Fatalf("unexpected count arguments %s", got)
+	}
+
+}
@@ -0,0 +1,204 @@
+// Package wmictest provides a fake wmic for testing code that uses the wmic
+// package, without running wmic
+package wmictest
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// errExit is the error returned with the stderr output of a failed query
+var errExit = errors.New("exit status 1")
+
+// invalidClass is the stderr output of wmic for a class that doesn't exist
+const invalidClass = "Node - LOCALHOST\r\nERROR:\r\nDescription = Invalid class.\r\n\r\n"
+
+// Command is a command run by a FakeRunner
+type Command struct {
+	Name string
+	Args []string
+	// Class is the class the command queried
+	Class string
+}
+
+// String returns the command line
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// FakeRunner is a wmic.Runner that returns canned wmic output for each class,
+// use it with wmic.WithRunner, e.g. wmic.NewClient(wmic.WithRunner(f)), for
+// any query including the streaming ones such as QueryIter and Exists.
+// Queries of a class without output fail with wmic's invalid class error and
+// PowerShell fails as if it isn't installed. It is safe for concurrent use
+type FakeRunner struct {
+	mu       sync.Mutex
+	outputs  map[string]string
+	errors   map[string]string
+	commands []Command
+}
+
+// NewFakeRunner returns a FakeRunner without any classes
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{outputs: map[string]string{}, errors: map[string]string{}}
+}
+
+// SetOutput sets the wmic output returned for the class, usually in the
+// /VALUE format. Class names are matched ignoring case
+func (f *FakeRunner) SetOutput(class, output string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outputs[strings.ToLower(class)] = output
+	delete(f.errors, strings.ToLower(class))
+}
+
+// SetRecords sets the output for the class to the records in the /VALUE
+// format, see Records
+func (f *FakeRunner) SetRecords(class string, records ...map[string]string) {
+	f.SetOutput(class, Records(records...))
+}
+
+// SetError makes queries of the class fail with the wmic stderr output, e.g.
+// "ERROR:\r\nCode = 0x80070005\r\nDescription = Access is denied.\r\n"
+func (f *FakeRunner) SetError(class, stderr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[strings.ToLower(class)] = stderr
+	delete(f.outputs, strings.ToLower(class))
+}
+
+// Run records the command and returns the output for its class
+func (f *FakeRunner) Run(ctx context.Context, name string, args []string) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := Command{Name: name, Args: append([]string{}, args...), Class: className(args)}
+	f.commands = append(f.commands, c)
+	if name == "powershell" {
+		return nil, nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	if stderr, ok := f.errors[strings.ToLower(c.Class)]; ok {
+		return nil, []byte(stderr), errExit
+	}
+	output, ok := f.outputs[strings.ToLower(c.Class)]
+	if !ok {
+		return nil, []byte(invalidClass), errExit
+	}
+	return []byte(output), nil, nil
+}
+
+// Commands returns the commands run so far, in order
+func (f *FakeRunner) Commands() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Command{}, f.commands...)
+}
+
+// Reset forgets the commands run so far, the outputs are kept
+func (f *FakeRunner) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = nil
+}
+
+// AssertQueried fails the test unless a command queried the class
+func (f *FakeRunner) AssertQueried(t testing.TB, class string) {
+	t.Helper()
+	for _, c := range f.Commands() {
+		if strings.EqualFold(c.Class, class) {
+			return
+		}
+	}
+	t.Errorf("Expected a query of %s, ran %v", class, f.Commands())
+}
+
+// AssertNotQueried fails the test if a command queried the class
+func (f *FakeRunner) AssertNotQueried(t testing.TB, class string) {
+	t.Helper()
+	for _, c := range f.Commands() {
+		if strings.EqualFold(c.Class, class) {
+			t.Errorf("Expected no query of %s, ran %s", class, c)
+		}
+	}
+}
+
+// AssertCommandCount fails the test unless n commands ran
+func (f *FakeRunner) AssertCommandCount(t testing.TB, n int) {
+	t.Helper()
+	if commands := f.Commands(); len(commands) != n {
+		t.Errorf("Expected %d commands, ran %d: %v", n, len(commands), commands)
+	}
+}
+
+// AssertRan fails the test unless a command had the arguments in order, e.g.
+// AssertRan(t, "WHERE", "(", "Name='Spooler'", ")")
+func (f *FakeRunner) AssertRan(t testing.TB, args ...string) {
+	t.Helper()
+	for _, c := range f.Commands() {
+		if containsArgs(c.Args, args) {
+			return
+		}
+	}
+	t.Errorf("Expected a command with %q, ran %v", args, f.Commands())
+}
+
+// containsArgs returns true if args contains want consecutively
+func containsArgs(args, want []string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		match := true
+		for j, w := range want {
+			if args[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// className returns the class or alias a wmic command is for, the first
+// argument after the global switches. The keys of an object path are removed
+func className(args []string) string {
+	for i, a := range args {
+		if strings.HasPrefix(a, "/") {
+			continue
+		}
+		if strings.EqualFold(a, "PATH") && i+1 < len(args) {
+			a = args[i+1]
+		}
+		if j := strings.IndexByte(a, '.'); j > 0 {
+			a = a[:j]
+		}
+		return a
+	}
+	return ""
+}
+
+// Records formats the records in the wmic /VALUE format, with the properties
+// of each record sorted by name as wmic does
+func Records(records ...map[string]string) string {
+	var b strings.Builder
+	b.WriteString("\r\r\n\r\r\n")
+	for _, r := range records {
+		names := make([]string, 0, len(r))
+		for name := range r {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteString(name + "=" + r[name] + "\r\r\n")
+		}
+		b.WriteString("\r\r\n\r\r\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package wmictest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cubewise-plim/wmic"
+)
+
+type service struct {
+	Name  string
+	State string
+}
+
+func TestFakeRunner(t *testing.T) {
+
+	f := NewFakeRunner()
+	f.SetRecords("Win32_Service", map[string]string{"Name": "Spooler", "State": "Running"}, map[string]string{"Name": "W32Time", "State": "Stopped"})
+	f.SetError("Win32_Process", "ERROR:\r\nCode = 0x80070005\r\nDescription = Access is denied.\r\n")
+	client := wmic.NewClient(wmic.WithRunner(f), wmic.WithNode("server1"))
+
+	out := []service{}
+	_, err := client.QueryWhere("win32_service", "State='Running'", &out)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(out) != 2 || out[0].Name != "Spooler" || out[1].State != "Stopped" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+	_, err = client.QueryAll("Win32_Process", &out)
+	if !errors.Is(err, wmic.ErrAccessDenied) {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+	_, err = client.QueryAll("Win32_Missing", &out)
+	if !errors.Is(err, wmic.ErrInvalidClass) {
+		t.Fatalf("expected an invalid class, got %v", err)
+	}
+
+	f.AssertCommandCount(t, 3)
+	f.AssertQueried(t, "Win32_Service")
+	f.AssertNotQueried(t, "Win32_BIOS")
+	f.AssertRan(t, "WHERE", "(", "State='Running'", ")")
+	f.AssertRan(t, `/NODE:"server1"`, "PATH", "Win32_Process")
+	if c := f.Commands()[0]; c.Class != "win32_service" || !strings.HasPrefix(c.String(), `wmic /NODE:"server1" PATH win32_service`) {
+		t.Fatalf("unexpected command %+v", c)
+	}
+
+	f.Reset()
+	f.AssertCommandCount(t, 0)
+
+}
+
+func TestFakeRunnerIter(t *testing.T) {
+
+	f := NewFakeRunner()
+	f.SetRecords("Win32_Service", map[string]string{"Name": "Spooler", "State": "Running"}, map[string]string{"Name": "W32Time", "State": "Stopped"})
+	client := wmic.NewClient(wmic.WithRunner(f))
+
+	ok, err := client.Exists("Win32_Service", "Name='Spooler'")
+	if err != nil || !ok {
+		t.Fatalf("expected an instance to exist, got %v %v", ok, err)
+	}
+	if _, err := client.Exists("Win32_Missing", ""); !errors.Is(err, wmic.ErrInvalidClass) {
+		t.Fatalf("expected an invalid class, got %v", err)
+	}
+
+	var s service
+	it, err := client.QueryIter(context.Background(), "Win32_Service", nil, "", &s)
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	names := []string{}
+	for it.Next() {
+		if err := it.Scan(&s); err != nil {
+			t.Fatalf("scan failed: %s", err)
+		}
+		names = append(names, s.Name)
+	}
+	it.Close()
+	if it.Err() != nil || strings.Join(names, ",") != "Spooler,W32Time" {
+		t.Fatalf("unexpected records %v %v", names, it.Err())
+	}
+
+	rows, err := wmic.QueryRows("Win32_Service", []string{"Name", "State"}, "", wmic.WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	var name, state string
+	if !rows.Next() || rows.Scan(&name, &state) != nil || name != "Spooler" || state != "Running" {
+		t.Fatalf("unexpected row %s %s %v", name, state, rows.Err())
+	}
+	rows.Close()
+
+	var buf bytes.Buffer
+	if err := client.QueryCSV(&buf, "Win32_Service", []string{"Name"}, ""); err != nil || buf.String() != "Name\r\nSpooler\r\nW32Time\r\n" {
+		t.Fatalf("unexpected CSV %q %v", buf.String(), err)
+	}
+	properties, err := client.ListProperties("Win32_Service")
+	if err != nil || strings.Join(properties, ",") != "Name,State" {
+		t.Fatalf("unexpected properties %v %v", properties, err)
+	}
+
+	f.AssertCommandCount(t, 6)
+	f.AssertRan(t, "PATH", "Win32_Service", "WHERE", "(", "Name='Spooler'", ")")
+
+}
+
+func TestFakeRunnerAssertions(t *testing.T) {
+
+	f := NewFakeRunner()
+	f.SetOutput("OS", "Caption=Windows\r\r\n\r\r\n")
+	_, err := wmic.QueryWith("OS", &[]struct{ Caption string }{}, wmic.WithRunner(f), wmic.WithAlias())
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+
+	tests := []func(tb testing.TB){
+		func(tb testing.TB) { f.AssertQueried(tb, "CPU") },
+		func(tb testing.TB) { f.AssertNotQueried(tb, "os") },
+		func(tb testing.TB) { f.AssertCommandCount(tb, 2) },
+		func(tb testing.TB) { f.AssertRan(tb, "GET", "Name") },
+	}
+	for i, test := range tests {
+		r := &recorder{TB: t}
+		test(r)
+		if !r.failed {
+			t.Errorf("expected assertion %d to fail", i)
+		}
+	}
+
+}
+
+// recorder records a failed assertion instead of failing the test
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+}
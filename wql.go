@@ -0,0 +1,86 @@
+package wmic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// wqlKeywords are the WQL keywords after WHERE that can't be passed to wmic's
+// PATH verb
+var wqlKeywords = map[string]bool{
+	"GROUP":  true,
+	"HAVING": true,
+	"WITHIN": true,
+	"BY":     true,
+}
+
+// QueryWQL populates the out slice from a WQL query, e.g.
+// SELECT Name,State FROM Win32_Service WHERE StartMode='Auto'. wmic has no
+// verb for WQL, so a SELECT with only a WHERE clause is run as PATH and GET.
+// Other queries, such as GROUP BY or ASSOCIATORS OF, are run with PowerShell's
+// Get-CimInstance -Query and fail with BackendWMIC. The PowerShell backend
+// always runs the query as is
+func QueryWQL(wql string, out interface{}, opts ...Option) ([]RecordError, error) {
+	return QueryWQLContext(context.Background(), wql, out, opts...)
+}
+
+// QueryWQLContext is QueryWQL with a context, the query stops when the context
+// is done
+func QueryWQLContext(ctx context.Context, wql string, out interface{}, opts ...Option) ([]RecordError, error) {
+	opts = append([]Option{}, opts...)
+	setWQL := func(o *QueryOptions) {
+		o.wql = wql
+	}
+	class, columns, where, ok := parseWQL(wql)
+	if !ok {
+		if newOptions(opts).Backend == BackendWMIC {
+			return []RecordError{}, fmt.Errorf("Query %q can't be run by wmic, use BackendPowerShell", wql)
+		}
+		return QueryWithContext(ctx, class, out, append(opts, WithBackend(BackendPowerShell), setWQL)...)
+	}
+	return QueryWithContext(ctx, class, out, append(opts, WithColumns(columns...), WithWhere(where), setWQL)...)
+}
+
+// parseWQL splits a SELECT query into the class, columns and where clause for
+// wmic. The bool is false if wmic can't run the query, the class is then the
+// one after FROM if there is one
+func parseWQL(wql string) (string, []string, string, bool) {
+	tokens := splitWhere(wql)
+	from := -1
+	for i, t := range tokens {
+		if strings.EqualFold(t, "FROM") {
+			from = i
+			break
+		}
+	}
+	if from < 0 || from == len(tokens)-1 {
+		return "", nil, "", false
+	}
+	class := tokens[from+1]
+	if from < 2 || !strings.EqualFold(tokens[0], "SELECT") || !isIdentifier(class) {
+		return class, nil, "", false
+	}
+
+	columns := []string{}
+	for _, c := range strings.Split(strings.Join(tokens[1:from], ""), ",") {
+		if c != AllColumns && !isIdentifier(c) {
+			return class, nil, "", false
+		}
+		columns = append(columns, c)
+	}
+
+	rest := tokens[from+2:]
+	if len(rest) == 0 {
+		return class, columns, "", true
+	}
+	if len(rest) == 1 || !strings.EqualFold(rest[0], "WHERE") {
+		return class, nil, "", false
+	}
+	for _, t := range rest[1:] {
+		if wqlKeywords[strings.ToUpper(t)] {
+			return class, nil, "", false
+		}
+	}
+	return class, columns, strings.Join(rest[1:], " "), true
+}
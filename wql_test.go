@@ -0,0 +1,92 @@
+package wmic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWQL(t *testing.T) {
+
+	tests := []struct {
+		wql     string
+		class   string
+		columns []string
+		where   string
+		ok      bool
+	}{
+		{"SELECT Name, State FROM Win32_Service WHERE StartMode='Auto' AND Name LIKE '% x'", "Win32_Service", []string{"Name", "State"}, "StartMode='Auto' AND Name LIKE '% x'", true},
+		{"select * from Win32_Process", "Win32_Process", []string{"*"}, "", true},
+		{"SELECT * FROM __InstanceCreationEvent WITHIN 5 WHERE TargetInstance ISA 'Win32_Process'", "__InstanceCreationEvent", nil, "", false},
+		{"SELECT * FROM Win32_Process WHERE Name='x' GROUP WITHIN 10", "Win32_Process", nil, "", false},
+		{`ASSOCIATORS OF {Win32_Service.Name="Spooler"}`, "", nil, "", false},
+		{"SELECT FROM Win32_Process", "Win32_Process", nil, "", false},
+	}
+	for _, test := range tests {
+		class, columns, where, ok := parseWQL(test.wql)
+		if class != test.class || !reflect.DeepEqual(columns, test.columns) || where != test.where || ok != test.ok {
+			t.Errorf("%s: got %q %q %q %v", test.wql, class, columns, where, ok)
+		}
+	}
+
+}
+
+func TestQueryWQL(t *testing.T) {
+
+	f := &fakeRunner{stdout: serviceOutput}
+	out := []win32Service{}
+	_, err := QueryWQL("SELECT DisplayName,Name,State FROM Win32_Service WHERE State='Running'", &out, WithRunner(f))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if got := strings.Join(f.args[0], " "); got != "PATH Win32_Service WHERE ( State='Running' ) GET DisplayName,Name,State /VALUE" {
+		t.Fatalf("unexpected arguments %s", got)
+	}
+	if len(out) != 2 || out[0].Name != "Spooler" {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+}
+
+func TestQueryWQLPowerShell(t *testing.T) {
+
+	wql := "SELECT * FROM Win32_Process WHERE Name='it''s' GROUP WITHIN 10"
+	b := &backendRunner{stdout: `[{"Name":"svchost.exe","ProcessId":1068}]`}
+	out := []psResult{}
+	_, err := QueryWQL(wql, &out, WithRunner(b))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if !reflect.DeepEqual(b.names, []string{"powershell"}) {
+		t.Fatalf("expected only powershell to run, ran %v", b.names)
+	}
+	script := b.args[0][len(b.args[0])-1]
+	if !strings.Contains(script, "Get-CimInstance -Query 'SELECT * FROM Win32_Process WHERE Name=''it''''s'' GROUP WITHIN 10' |") {
+		t.Fatalf("expected the query in %s", script)
+	}
+	if len(out) != 1 || out[0].ProcessId != 1068 {
+		t.Fatalf("unexpected records %+v", out)
+	}
+
+	b = &backendRunner{stdout: `[]`}
+	_, err = QueryWQL(`ASSOCIATORS OF {Win32_Service.Name="Spooler"}`, &out, WithRunner(b))
+	if err != nil || !reflect.DeepEqual(b.names, []string{"powershell"}) {
+		t.Fatalf("expected the query to run with powershell, ran %v: %v", b.names, err)
+	}
+
+	_, err = QueryWQL(wql, &out, WithRunner(b), WithBackend(BackendWMIC))
+	if err == nil || len(b.names) != 1 {
+		t.Fatalf("expected an error without running wmic, got %v", err)
+	}
+
+	// The fallback from wmic runs the query as is
+	b = &backendRunner{stdout: `[]`}
+	_, err = QueryWQL("SELECT Name FROM Win32_Process", &out, WithRunner(b))
+	if err != nil || !reflect.DeepEqual(b.names, []string{"wmic", "powershell"}) {
+		t.Fatalf("expected a fallback to powershell, ran %v: %v", b.names, err)
+	}
+	if script := b.args[1][len(b.args[1])-1]; !strings.Contains(script, "Get-CimInstance -Query 'SELECT Name FROM Win32_Process'") {
+		t.Fatalf("expected the query in %s", script)
+	}
+
+}
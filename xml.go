@@ -0,0 +1,83 @@
+package wmic
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Format is the wmic output format
+type Format int
+
+const (
+	// FormatValue requests /VALUE output of name=value lines
+	FormatValue Format = iota
+	// FormatXML requests /format:rawxml output
+	FormatXML
+)
+
+// xmlProperty is a PROPERTY or PROPERTY.ARRAY element of a RAWXML instance
+type xmlProperty struct {
+	XMLName xml.Name
+	Name    string   `xml:"NAME,attr"`
+	Value   *string  `xml:"VALUE"`
+	Values  []string `xml:"VALUE.ARRAY>VALUE"`
+}
+
+// xmlInstance is an INSTANCE element of RAWXML output
+type xmlInstance struct {
+	Properties []xmlProperty `xml:",any"`
+}
+
+// xmlReader reads the instances from wmic /format:rawxml output, which has
+// the form <COMMAND><RESULTS><CIM><INSTANCE><PROPERTY NAME="...">
+type xmlReader struct {
+	dec *xml.Decoder
+}
+
+func newXMLReader(r io.Reader) *xmlReader {
+	dec := xml.NewDecoder(utf8Reader(r))
+	// The output has been converted to UTF-8 whatever the declaration says
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return &xmlReader{dec: dec}
+}
+
+// next returns the properties of the next instance. Null properties, which
+// have no VALUE element, are left out so pointer fields stay nil
+func (xr *xmlReader) next() ([]property, error) {
+	for {
+		t, err := xr.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != "INSTANCE" {
+			continue
+		}
+		var instance xmlInstance
+		err = xr.dec.DecodeElement(&instance, &start)
+		if err != nil {
+			return nil, err
+		}
+		props := []property{}
+		for _, p := range instance.Properties {
+			switch p.XMLName.Local {
+			case "PROPERTY":
+				if p.Value != nil {
+					props = append(props, property{name: p.Name, value: *p.Value})
+				}
+			case "PROPERTY.ARRAY":
+				if p.Values != nil {
+					elems := make([]string, len(p.Values))
+					for i, v := range p.Values {
+						elems[i] = `"` + v + `"`
+					}
+					props = append(props, property{name: p.Name, value: "{" + strings.Join(elems, ",") + "}", elems: p.Values})
+				}
+			}
+		}
+		return props, nil
+	}
+}
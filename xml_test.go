@@ -0,0 +1,61 @@
+package wmic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type adapterConfig struct {
+	Description string
+	IPAddress   []string
+	IPEnabled   bool
+	DNSDomain   *string
+}
+
+const adapterXML = `<COMMAND SEQUENCENUM="1" ISSUEDFROM="PC" STARTTIME="20231105143000"><REQUEST><COMMANDLINE>wmic</COMMANDLINE></REQUEST><RESULTS NODE="PC"><CIM><INSTANCE CLASSNAME="Win32_NetworkAdapterConfiguration"><PROPERTY NAME="Description" TYPE="string"><VALUE>Intel(R) Ethernet
+Connection</VALUE></PROPERTY><PROPERTY.ARRAY NAME="IPAddress" TYPE="string"><VALUE.ARRAY><VALUE>10.0.0.1</VALUE><VALUE>fe80::1</VALUE></VALUE.ARRAY></PROPERTY.ARRAY><PROPERTY NAME="IPEnabled" TYPE="boolean"><VALUE>TRUE</VALUE></PROPERTY><PROPERTY NAME="DNSDomain" TYPE="string"></PROPERTY></INSTANCE><INSTANCE CLASSNAME="Win32_NetworkAdapterConfiguration"><PROPERTY NAME="Description" TYPE="string"><VALUE>WAN Miniport</VALUE></PROPERTY><PROPERTY.ARRAY NAME="IPAddress" TYPE="string"></PROPERTY.ARRAY><PROPERTY NAME="IPEnabled" TYPE="boolean"><VALUE>FALSE</VALUE></PROPERTY></INSTANCE></CIM></RESULTS></COMMAND>`
+
+func TestDecodeXML(t *testing.T) {
+
+	f := &fakeRunner{stdout: adapterXML}
+	out := []adapterConfig{}
+	_, err := QueryWith("Win32_NetworkAdapterConfiguration", &out, WithRunner(f), WithFormat(FormatXML))
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if args := strings.Join(f.args[0], " "); !strings.HasSuffix(args, "/format:rawxml") || strings.Contains(args, "/VALUE") {
+		t.Fatalf("unexpected args %s", args)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 records, got %+v", out)
+	}
+	if out[0].Description != "Intel(R) Ethernet\nConnection" || !reflect.DeepEqual(out[0].IPAddress, []string{"10.0.0.1", "fe80::1"}) || !out[0].IPEnabled || out[0].DNSDomain != nil {
+		t.Errorf("unexpected record %+v", out[0])
+	}
+	if out[1].Description != "WAN Miniport" || out[1].IPAddress != nil || out[1].IPEnabled {
+		t.Errorf("unexpected record %+v", out[1])
+	}
+
+}
+
+func TestDecodeXMLArrayElements(t *testing.T) {
+
+	data := `<COMMAND><RESULTS NODE="PC"><CIM><INSTANCE CLASSNAME="Win32_Environment"><PROPERTY.ARRAY NAME="Paths" TYPE="string"><VALUE.ARRAY><VALUE>C:\Program Files\a,b</VALUE><VALUE>say "hi", bye</VALUE><VALUE>c</VALUE></VALUE.ARRAY></PROPERTY.ARRAY><PROPERTY.ARRAY NAME="Pair" TYPE="string"><VALUE.ARRAY><VALUE>x,y</VALUE><VALUE>z</VALUE></VALUE.ARRAY></PROPERTY.ARRAY><PROPERTY.ARRAY NAME="Sizes" TYPE="uint64"><VALUE.ARRAY><VALUE>512</VALUE><VALUE>1024</VALUE></VALUE.ARRAY></PROPERTY.ARRAY></INSTANCE></CIM></RESULTS></COMMAND>`
+	out := []struct {
+		Paths []string
+		Pair  *[2]string
+		Sizes []uint64
+	}{}
+	_, err := decodeRecords(newXMLReader(strings.NewReader(data)), "Win32_Environment", &out, &QueryOptions{})
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if len(out) != 1 || !reflect.DeepEqual(out[0].Paths, []string{`C:\Program Files\a,b`, `say "hi", bye`, "c"}) {
+		t.Fatalf("expected the element boundaries to be kept, got %+v", out)
+	}
+	if out[0].Pair == nil || *out[0].Pair != [2]string{"x,y", "z"} || !reflect.DeepEqual(out[0].Sizes, []uint64{512, 1024}) {
+		t.Fatalf("unexpected record %+v", out[0])
+	}
+
+}